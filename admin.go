@@ -0,0 +1,116 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/greenpau/caddy-appd/pkg/services"
+)
+
+// adminReloadPath is the path prefix of the admin endpoint reloading the
+// apps, followed by the name of the app.
+const adminReloadPath = "/appd/reload/"
+
+var (
+	// Interface guards
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+)
+
+func init() {
+	caddy.RegisterModule(AdminAPI{})
+}
+
+// active holds the service manager of the running app. During a config
+// reload, the app of the new config starts prior to the stop of the old
+// one.
+var active struct {
+	sync.Mutex
+	manager *services.Manager
+}
+
+// setActiveManager makes the service manager serve the admin API.
+func setActiveManager(m *services.Manager) {
+	active.Lock()
+	defer active.Unlock()
+	active.manager = m
+}
+
+// clearActiveManager stops the service manager from serving the admin API,
+// unless another one has replaced it.
+func clearActiveManager(m *services.Manager) {
+	active.Lock()
+	defer active.Unlock()
+	if active.manager == m {
+		active.manager = nil
+	}
+}
+
+// AdminAPI is the admin API of the service manager.
+type AdminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  caddy.ModuleID("admin.api." + appName),
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes returns the routes of the admin API.
+func (AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: adminReloadPath,
+			Handler: caddy.AdminHandlerFunc(handleReload),
+		},
+	}
+}
+
+// handleReload reloads the app with the name in the request path.
+func handleReload(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	name := strings.TrimPrefix(r.URL.Path, adminReloadPath)
+	active.Lock()
+	m := active.manager
+	active.Unlock()
+	if m == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusServiceUnavailable,
+			Err:        fmt.Errorf("service manager is not running"),
+		}
+	}
+	if msgs := m.ReloadService(name); len(msgs) > 0 {
+		status := http.StatusInternalServerError
+		if errors.Is(msgs[0].Error, services.ErrServiceNotFound) {
+			status = http.StatusNotFound
+		}
+		return caddy.APIError{
+			HTTPStatus: status,
+			Err:        fmt.Errorf("failed reloading %q service: %w", name, msgs[0].Error),
+		}
+	}
+	return nil
+}
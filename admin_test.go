@@ -0,0 +1,107 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package appd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/greenpau/caddy-appd/pkg/services"
+	"go.uber.org/zap"
+)
+
+func TestAdminReload(t *testing.T) {
+	cfg := services.NewConfig()
+	unit := &services.Unit{
+		Name:       "webapp",
+		Kind:       "app",
+		Command:    "sleep",
+		Arguments:  []string{"60"},
+		ExecReload: &services.Exec{Command: "true"},
+	}
+	if err := cfg.AddUnit(unit); err != nil {
+		t.Fatal(err)
+	}
+	m, err := services.NewManager(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name   string
+		method string
+		path   string
+		start  bool
+		want   int
+	}{
+		{
+			name:   "test reload app",
+			method: http.MethodPost,
+			path:   "/appd/reload/webapp",
+			start:  true,
+			want:   http.StatusOK,
+		},
+		{
+			name:   "test reload unknown app",
+			method: http.MethodPost,
+			path:   "/appd/reload/database",
+			start:  true,
+			want:   http.StatusNotFound,
+		},
+		{
+			name:   "test reload app with invalid method",
+			method: http.MethodGet,
+			path:   "/appd/reload/webapp",
+			start:  true,
+			want:   http.StatusMethodNotAllowed,
+		},
+		{
+			name:   "test reload app without running manager",
+			method: http.MethodPost,
+			path:   "/appd/reload/webapp",
+			want:   http.StatusServiceUnavailable,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.start {
+				if msgs := m.Start(); msgs != nil {
+					t.Fatalf("expected success, got: %v", msgs[0].Error)
+				}
+				setActiveManager(m)
+				defer func() {
+					clearActiveManager(m)
+					m.Stop()
+				}()
+			}
+			rec := httptest.NewRecorder()
+			err := handleReload(rec, httptest.NewRequest(tc.method, tc.path, nil))
+			got := http.StatusOK
+			if err != nil {
+				var apiErr caddy.APIError
+				if !errors.As(err, &apiErr) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				got = apiErr.HTTPStatus
+			}
+			if got != tc.want {
+				t.Errorf("unexpected status: %d, want: %d, error: %v", got, tc.want, err)
+			}
+		})
+	}
+}
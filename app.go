@@ -99,6 +99,7 @@ func (app App) Start() error {
 			return fmt.Errorf("service manager failed to start services")
 		}
 	}
+	setActiveManager(app.manager)
 
 	app.logger.Debug(
 		"started service manager",
@@ -114,6 +115,7 @@ func (app App) Stop() error {
 		"stopping service manager",
 		zap.String("app", app.Name),
 	)
+	clearActiveManager(app.manager)
 
 	if msgs := app.manager.Stop(); msgs != nil {
 		for _, msg := range msgs {
@@ -16,7 +16,10 @@ package appd
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
@@ -32,10 +35,83 @@ func init() {
 // Syntax:
 //
 // appd {
+//   max_parallel_starts <number>
+//   start_jitter <duration>
+//   env_passthrough <pattern> [pattern2] ... [patternN]
+//   cgroup_root <path/to/cgroup>
+//
 //   <command|app> <alias> {
 //     workdir <path/to/dir>
+//     env <key> <value>
+//     env_file <path/to/file>
+//     clear_env
+//     env_passthrough <pattern> [pattern2] ... [patternN]
+//     user <name|id>
+//     group <name|id>
+//     supplementary_groups <name|id> [group2] ... [groupN]
+//     limit_nofile <limit|soft:hard>
+//     limit_nproc <limit|soft:hard>
+//     limit_core <limit|soft:hard>
+//     limit_as <limit|soft:hard>
+//     limit_cpu <limit|soft:hard>
+//     limit_stack <limit|soft:hard>
+//     cgroup
+//     memory_max <size|max>
+//     memory_high <size|max>
+//     cpu_max <percentage%>
+//     cpu_max <quota> [period]
+//     cpu_weight <1-10000>
+//     pids_max <number|max>
+//     io_weight <1-10000>
+//     private_tmp
+//     protect_system
+//     read_only_paths <path> [path2] ... [pathN]
+//     read_write_paths <path> [path2] ... [pathN]
+//     inaccessible_paths <path> [path2] ... [pathN]
+//     private_network
+//     listen_socket <path/to/socket>
+//     capabilities <capability> [capability2] ... [capabilityN]
+//     no_new_privileges
+//     syscall_filter <[~]syscall|@group> [[~]syscall2|@group2] ... [[~]syscallN|@groupN]
+//     landlock {
+//       readable <path> [path2] ... [pathN]
+//       writable <path> [path2] ... [pathN]
+//       required
+//     }
 //     cmd <path/to/command> [args]
+//     type <simple|notify>
 //     args [arg1] [arg2] ... [argN]
+//     before <alias> [alias2] ... [aliasN]
+//     after <alias> [alias2] ... [aliasN]
+//     wants <alias> [alias2] ... [aliasN]
+//     requires <alias> [alias2] ... [aliasN]
+//     priority <number>
+//     restart <no|always|on-failure|on-abnormal>
+//     restart_delay <duration>
+//     restart_max_delay <duration>
+//     start_limit_burst <number>
+//     start_limit_interval <duration>
+//     ready_probe tcp <host:port>
+//     ready_probe http <url> [status_code]
+//     ready_probe unix <path/to/socket>
+//     ready_probe exec <path/to/command> [args]
+//     ready_interval <duration>
+//     ready_timeout <duration>
+//     health_probe <tcp|http|unix|exec> <target> [args]
+//     health_interval <duration>
+//     health_timeout <duration>
+//     health_failure_threshold <number>
+//     watchdog_interval <duration>
+//     watchdog_signal <signal>
+//     kill_mode <process|group|mixed>
+//     stop_signal <signal>
+//     stop_timeout <duration>
+//     stop_escalation <signal>[:<duration>] ... [signalN[:<duration>]]
+//     exec_stop <path/to/command> [args]
+//     exec_reload <path/to/command> [args]
+//     exec_start_pre <path/to/command> [args]
+//     exec_start_post <path/to/command> [args]
+//     exec_stop_post <path/to/command> [args]
 //   }
 //
 //   command hostname {
@@ -53,9 +129,70 @@ func init() {
 // }
 
 var argRules = map[string]argRule{
-	"cmd":  argRule{Min: 1, Max: 255},
-	"args": argRule{Min: 1, Max: 255},
-	"noop": argRule{},
+	"workdir":                  argRule{Min: 1, Max: 1},
+	"env":                      argRule{Min: 2, Max: 2},
+	"env_file":                 argRule{Min: 1, Max: 1},
+	"clear_env":                argRule{},
+	"env_passthrough":          argRule{Min: 1, Max: 255},
+	"user":                     argRule{Min: 1, Max: 1},
+	"group":                    argRule{Min: 1, Max: 1},
+	"supplementary_groups":     argRule{Min: 1, Max: 255},
+	"limit_nofile":             argRule{Min: 1, Max: 1},
+	"limit_nproc":              argRule{Min: 1, Max: 1},
+	"limit_core":               argRule{Min: 1, Max: 1},
+	"limit_as":                 argRule{Min: 1, Max: 1},
+	"limit_cpu":                argRule{Min: 1, Max: 1},
+	"limit_stack":              argRule{Min: 1, Max: 1},
+	"cgroup":                   argRule{},
+	"memory_max":               argRule{Min: 1, Max: 1},
+	"memory_high":              argRule{Min: 1, Max: 1},
+	"cpu_max":                  argRule{Min: 1, Max: 2},
+	"cpu_weight":               argRule{Min: 1, Max: 1},
+	"pids_max":                 argRule{Min: 1, Max: 1},
+	"io_weight":                argRule{Min: 1, Max: 1},
+	"private_tmp":              argRule{},
+	"protect_system":           argRule{},
+	"read_only_paths":          argRule{Min: 1, Max: 255},
+	"read_write_paths":         argRule{Min: 1, Max: 255},
+	"inaccessible_paths":       argRule{Min: 1, Max: 255},
+	"private_network":          argRule{},
+	"listen_socket":            argRule{Min: 1, Max: 1},
+	"capabilities":             argRule{Min: 1, Max: 255},
+	"no_new_privileges":        argRule{},
+	"syscall_filter":           argRule{Min: 1, Max: 255},
+	"landlock":                 argRule{},
+	"cmd":                      argRule{Min: 1, Max: 255},
+	"args":                     argRule{Min: 1, Max: 255},
+	"before":                   argRule{Min: 1, Max: 255},
+	"after":                    argRule{Min: 1, Max: 255},
+	"wants":                    argRule{Min: 1, Max: 255},
+	"requires":                 argRule{Min: 1, Max: 255},
+	"type":                     argRule{Min: 1, Max: 1},
+	"priority":                 argRule{Min: 1, Max: 1},
+	"restart":                  argRule{Min: 1, Max: 1},
+	"restart_delay":            argRule{Min: 1, Max: 1},
+	"restart_max_delay":        argRule{Min: 1, Max: 1},
+	"start_limit_burst":        argRule{Min: 1, Max: 1},
+	"start_limit_interval":     argRule{Min: 1, Max: 1},
+	"ready_probe":              argRule{Min: 2, Max: 255},
+	"ready_interval":           argRule{Min: 1, Max: 1},
+	"ready_timeout":            argRule{Min: 1, Max: 1},
+	"health_probe":             argRule{Min: 2, Max: 255},
+	"health_interval":          argRule{Min: 1, Max: 1},
+	"health_timeout":           argRule{Min: 1, Max: 1},
+	"health_failure_threshold": argRule{Min: 1, Max: 1},
+	"watchdog_interval":        argRule{Min: 1, Max: 1},
+	"watchdog_signal":          argRule{Min: 1, Max: 1},
+	"kill_mode":                argRule{Min: 1, Max: 1},
+	"stop_signal":              argRule{Min: 1, Max: 1},
+	"stop_timeout":             argRule{Min: 1, Max: 1},
+	"stop_escalation":          argRule{Min: 1, Max: 255},
+	"exec_stop":                argRule{Min: 1, Max: 255},
+	"exec_reload":              argRule{Min: 1, Max: 255},
+	"exec_start_pre":           argRule{Min: 1, Max: 255},
+	"exec_start_post":          argRule{Min: 1, Max: 255},
+	"exec_stop_post":           argRule{Min: 1, Max: 255},
+	"noop":                     argRule{},
 }
 
 type argRule struct {
@@ -99,6 +236,252 @@ func parseCaddyfile(d *caddyfile.Dispenser, _ interface{}) (interface{}, error)
 					unit.Command = v[0]
 				case "args":
 					unit.Arguments = append(unit.Arguments, v...)
+				case "before":
+					unit.Before = append(unit.Before, v...)
+				case "after":
+					unit.After = append(unit.After, v...)
+				case "wants":
+					unit.Wants = append(unit.Wants, v...)
+				case "requires":
+					unit.Requires = append(unit.Requires, v...)
+				case "workdir":
+					unit.WorkDirectory = v[0]
+				case "env":
+					if unit.Environment == nil {
+						unit.Environment = make(map[string]string)
+					}
+					unit.Environment[v[0]] = v[1]
+				case "env_file":
+					unit.EnvFiles = append(unit.EnvFiles, v[0])
+				case "clear_env":
+					unit.ClearEnv = true
+				case "env_passthrough":
+					unit.EnvPassthrough = append(unit.EnvPassthrough, v...)
+				case "user":
+					unit.User = v[0]
+				case "group":
+					unit.Group = v[0]
+				case "supplementary_groups":
+					unit.SupplementaryGroups = append(unit.SupplementaryGroups, v...)
+				case "limit_nofile", "limit_nproc", "limit_core", "limit_as", "limit_cpu", "limit_stack":
+					resource := strings.TrimPrefix(k, "limit_")
+					limit, err := services.ParseRlimit(resource, v[0])
+					if err != nil {
+						return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+					}
+					if unit.Limits == nil {
+						unit.Limits = make(map[string]*services.Rlimit)
+					}
+					unit.Limits[resource] = limit
+				case "cgroup":
+					unit.Cgroup = true
+				case "memory_max", "memory_high":
+					var n uint64
+					if v[0] != "max" {
+						var err error
+						n, err = services.ParseByteSize(v[0])
+						if err != nil || n == 0 {
+							return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+						}
+					}
+					if k == "memory_max" {
+						unit.MemoryMax = n
+					} else {
+						unit.MemoryHigh = n
+					}
+				case "cpu_max":
+					c, err := services.ParseCPUMax(v)
+					if err != nil {
+						return nil, d.Errf("invalid %q value for %q directive", strings.Join(v, " "), k)
+					}
+					unit.CPUMax = c
+				case "cpu_weight", "io_weight":
+					n, err := strconv.ParseUint(v[0], 10, 64)
+					if err != nil || n < 1 || n > 10000 {
+						return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+					}
+					if k == "cpu_weight" {
+						unit.CPUWeight = n
+					} else {
+						unit.IOWeight = n
+					}
+				case "pids_max":
+					var n uint64
+					if v[0] != "max" {
+						var err error
+						n, err = strconv.ParseUint(v[0], 10, 64)
+						if err != nil || n == 0 {
+							return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+						}
+					}
+					unit.PidsMax = n
+				case "private_tmp":
+					unit.PrivateTmp = true
+				case "protect_system":
+					unit.ProtectSystem = true
+				case "read_only_paths":
+					unit.ReadOnlyPaths = append(unit.ReadOnlyPaths, v...)
+				case "read_write_paths":
+					unit.ReadWritePaths = append(unit.ReadWritePaths, v...)
+				case "inaccessible_paths":
+					unit.InaccessiblePaths = append(unit.InaccessiblePaths, v...)
+				case "private_network":
+					unit.PrivateNetwork = true
+				case "listen_socket":
+					unit.ListenSockets = append(unit.ListenSockets, v[0])
+				case "capabilities":
+					unit.Capabilities = append(unit.Capabilities, v...)
+				case "no_new_privileges":
+					unit.NoNewPrivileges = true
+				case "syscall_filter":
+					unit.SyscallFilter = append(unit.SyscallFilter, v...)
+				case "landlock":
+					if unit.Landlock == nil {
+						unit.Landlock = &services.Landlock{}
+					}
+					if err := parseLandlock(d, unit.Landlock); err != nil {
+						return nil, err
+					}
+				case "type":
+					switch v[0] {
+					case services.SimpleServiceType, services.NotifyServiceType:
+					default:
+						return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+					}
+					unit.ServiceType = v[0]
+				case "priority":
+					n, err := strconv.ParseUint(v[0], 10, 64)
+					if err != nil {
+						return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+					}
+					unit.Priority = n
+				case "restart":
+					switch v[0] {
+					case services.RestartNo, services.RestartAlways, services.RestartOnFailure, services.RestartOnAbnormal:
+					default:
+						return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+					}
+					unit.Restart = v[0]
+				case "restart_delay":
+					dur, err := parseDurationArg(k, v[0])
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					unit.RestartDelay = dur
+				case "restart_max_delay":
+					dur, err := parseDurationArg(k, v[0])
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					unit.RestartMaxDelay = dur
+				case "start_limit_burst":
+					n, err := strconv.Atoi(v[0])
+					if err != nil || n < 1 {
+						return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+					}
+					unit.StartLimitBurst = n
+				case "start_limit_interval":
+					dur, err := parseDurationArg(k, v[0])
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					unit.StartLimitInterval = dur
+				case "ready_probe":
+					probe, err := parseProbe(k, v)
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					unit.ReadyProbe = mergeProbe(unit.ReadyProbe, probe)
+				case "ready_interval":
+					dur, err := parseDurationArg(k, v[0])
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					if unit.ReadyProbe == nil {
+						unit.ReadyProbe = &services.Probe{}
+					}
+					unit.ReadyProbe.Interval = dur
+				case "ready_timeout":
+					dur, err := parseDurationArg(k, v[0])
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					unit.ReadyTimeout = dur
+				case "health_probe":
+					probe, err := parseProbe(k, v)
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					unit.HealthProbe = mergeProbe(unit.HealthProbe, probe)
+				case "health_interval", "health_timeout":
+					dur, err := parseDurationArg(k, v[0])
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					if unit.HealthProbe == nil {
+						unit.HealthProbe = &services.Probe{}
+					}
+					if k == "health_interval" {
+						unit.HealthProbe.Interval = dur
+					} else {
+						unit.HealthProbe.Timeout = dur
+					}
+				case "health_failure_threshold":
+					n, err := strconv.Atoi(v[0])
+					if err != nil || n < 1 {
+						return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+					}
+					if unit.HealthProbe == nil {
+						unit.HealthProbe = &services.Probe{}
+					}
+					unit.HealthProbe.FailureThreshold = n
+				case "watchdog_interval":
+					dur, err := parseDurationArg(k, v[0])
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					unit.WatchdogInterval = dur
+				case "watchdog_signal":
+					unit.WatchdogSignal = v[0]
+				case "kill_mode":
+					switch v[0] {
+					case services.KillModeProcess, services.KillModeGroup, services.KillModeMixed:
+					default:
+						return nil, d.Errf("invalid %q value for %q directive", v[0], k)
+					}
+					unit.KillMode = v[0]
+				case "stop_signal":
+					unit.StopSignal = v[0]
+				case "stop_timeout":
+					dur, err := parseDurationArg(k, v[0])
+					if err != nil {
+						return nil, d.Errf("%s", err)
+					}
+					unit.StopTimeout = dur
+				case "stop_escalation":
+					for _, arg := range v {
+						step := &services.StopStep{}
+						sig, timeout, found := strings.Cut(arg, ":")
+						step.Signal = sig
+						if found {
+							dur, err := parseDurationArg(k, timeout)
+							if err != nil {
+								return nil, d.Errf("%s", err)
+							}
+							step.Timeout = dur
+						}
+						unit.StopEscalation = append(unit.StopEscalation, step)
+					}
+				case "exec_stop":
+					unit.ExecStop = &services.Exec{Command: v[0], Arguments: v[1:]}
+				case "exec_reload":
+					unit.ExecReload = &services.Exec{Command: v[0], Arguments: v[1:]}
+				case "exec_start_pre":
+					unit.ExecStartPre = append(unit.ExecStartPre, &services.Exec{Command: v[0], Arguments: v[1:]})
+				case "exec_start_post":
+					unit.ExecStartPost = append(unit.ExecStartPost, &services.Exec{Command: v[0], Arguments: v[1:]})
+				case "exec_stop_post":
+					unit.ExecStopPost = append(unit.ExecStopPost, &services.Exec{Command: v[0], Arguments: v[1:]})
 				case "stdout_file":
 					unit.StdOutFilePath = v[0]
 				case "stderr_file":
@@ -113,6 +496,38 @@ func parseCaddyfile(d *caddyfile.Dispenser, _ interface{}) (interface{}, error)
 			if err := app.Config.AddUnit(unit); err != nil {
 				return nil, d.Err(err.Error())
 			}
+		case "max_parallel_starts":
+			args := d.RemainingArgs()
+			if len(args) != 1 {
+				return nil, d.ArgErr()
+			}
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return nil, d.Errf("invalid %q value for %q directive", args[0], "max_parallel_starts")
+			}
+			app.Config.MaxParallelStarts = n
+		case "start_jitter":
+			args := d.RemainingArgs()
+			if len(args) != 1 {
+				return nil, d.ArgErr()
+			}
+			dur, err := parseDurationArg("start_jitter", args[0])
+			if err != nil {
+				return nil, d.Errf("%s", err)
+			}
+			app.Config.StartJitter = dur
+		case "env_passthrough":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return nil, d.ArgErr()
+			}
+			app.Config.EnvPassthrough = append(app.Config.EnvPassthrough, args...)
+		case "cgroup_root":
+			args := d.RemainingArgs()
+			if len(args) != 1 {
+				return nil, d.ArgErr()
+			}
+			app.Config.CgroupRoot = args[0]
 		default:
 			return nil, d.ArgErr()
 		}
@@ -137,3 +552,79 @@ func validateArg(k string, v []string) error {
 	}
 	return nil
 }
+
+func parseDurationArg(k, v string) (services.Duration, error) {
+	dur, err := caddy.ParseDuration(v)
+	if err != nil || dur < 0 {
+		return 0, fmt.Errorf("invalid %q value for %q directive", v, k)
+	}
+	return services.Duration(dur), nil
+}
+
+func parseProbe(k string, v []string) (*services.Probe, error) {
+	probe := &services.Probe{
+		Kind:   v[0],
+		Target: v[1],
+	}
+	switch probe.Kind {
+	case services.TCPProbe, services.UnixProbe:
+		if len(v) > 2 {
+			return nil, fmt.Errorf("too many args for %q directive", k)
+		}
+	case services.HTTPProbe:
+		if len(v) > 3 {
+			return nil, fmt.Errorf("too many args for %q directive", k)
+		}
+		if len(v) == 3 {
+			n, err := strconv.Atoi(v[2])
+			if err != nil || n < 100 || n > 599 {
+				return nil, fmt.Errorf("invalid %q status code for %q directive", v[2], k)
+			}
+			probe.ExpectedStatus = n
+		}
+	case services.ExecProbe:
+		probe.Arguments = v[2:]
+	default:
+		return nil, fmt.Errorf("invalid %q probe type for %q directive", v[0], k)
+	}
+	return probe, nil
+}
+
+// parseLandlock parses the settings of the landlock block.
+func parseLandlock(d *caddyfile.Dispenser, landlock *services.Landlock) error {
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		k := d.Val()
+		v := d.RemainingArgs()
+		switch k {
+		case "readable", "writable":
+			if len(v) == 0 {
+				return d.Errf("too few args for %q directive", k)
+			}
+			if k == "readable" {
+				landlock.Readable = append(landlock.Readable, v...)
+			} else {
+				landlock.Writable = append(landlock.Writable, v...)
+			}
+		case "required":
+			if len(v) > 0 {
+				return d.Errf("too many args for %q directive", k)
+			}
+			landlock.Required = true
+		default:
+			return d.Errf("unsupported %q key", k)
+		}
+	}
+	return nil
+}
+
+// mergeProbe returns the probe with the settings previously configured by
+// the interval, timeout, and failure threshold directives.
+func mergeProbe(existing, probe *services.Probe) *services.Probe {
+	if existing == nil {
+		return probe
+	}
+	probe.Interval = existing.Interval
+	probe.Timeout = existing.Timeout
+	probe.FailureThreshold = existing.FailureThreshold
+	return probe
+}
@@ -76,6 +76,686 @@ func TestParseCaddyfile(t *testing.T) {
               }
 			}`,
 		},
+		{
+			name: "test parse config with dependency directives",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                after migrator
+                requires database
+                wants cache
+              }
+              app cache {
+                cmd memcached
+              }
+              app database {
+                cmd postgres
+                before migrator
+              }
+              command migrator {
+                cmd migrate
+                priority 10
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"after":["migrator"],
+					"requires":["database"],
+					"wants":["cache"],
+					"seq": 1
+                  },
+                  {
+                    "name":"cache",
+					"cmd":"memcached",
+					"kind":"app",
+					"seq": 2
+                  },
+                  {
+                    "name":"database",
+					"cmd":"postgres",
+					"kind":"app",
+					"before":["migrator"],
+					"seq": 3
+                  },
+                  {
+                    "name":"migrator",
+                    "cmd":"migrate",
+					"kind":"command",
+					"priority": 10,
+					"seq": 4
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with parallel start options",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              max_parallel_starts 4
+              start_jitter 500ms
+              command hostname {
+                cmd hostname
+              }
+            }`),
+			want: `{
+			  "config": {
+                "max_parallel_starts": 4,
+                "start_jitter": 500000000,
+                "units": [
+                  {
+                    "name":"hostname",
+					"cmd":"hostname",
+					"kind":"command",
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with invalid max parallel starts",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              max_parallel_starts -1
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("invalid %q value for %q directive, at %s:%d", "-1", "max_parallel_starts", tf, 3),
+		},
+		{
+			name: "test parse config with restart policy",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                restart on-failure
+                restart_delay 500ms
+                restart_max_delay 1m
+                start_limit_burst 3
+                start_limit_interval 30s
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"restart":"on-failure",
+					"restart_delay": 500000000,
+					"restart_max_delay": 60000000000,
+					"start_limit_burst": 3,
+					"start_limit_interval": 30000000000,
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with invalid restart policy",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                restart sometimes
+              }
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("invalid %q value for %q directive, at %s:%d", "sometimes", "restart", tf, 4),
+		},
+		{
+			name: "test parse config with notify service type",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                type notify
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"type":"notify",
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with invalid service type",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                type forking
+              }
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("invalid %q value for %q directive, at %s:%d", "forking", "type", tf, 4),
+		},
+		{
+			name: "test parse config with watchdog",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                type notify
+                watchdog_interval 30s
+                watchdog_signal SIGQUIT
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"type":"notify",
+					"watchdog_interval": 30000000000,
+					"watchdog_signal": "SIGQUIT",
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with stop settings",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                kill_mode mixed
+                stop_signal SIGTERM
+                stop_timeout 30s
+                stop_escalation SIGTERM:30s SIGQUIT:10s SIGKILL
+                exec_stop webapp --stop
+                exec_reload kill -HUP $MAINPID
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"kill_mode": "mixed",
+					"stop_signal": "SIGTERM",
+					"stop_timeout": 30000000000,
+					"stop_escalation": [
+					  {"signal": "SIGTERM", "timeout": 30000000000},
+					  {"signal": "SIGQUIT", "timeout": 10000000000},
+					  {"signal": "SIGKILL"}
+					],
+					"exec_stop": {"cmd": "webapp", "args": ["--stop"]},
+					"exec_reload": {"cmd": "kill", "args": ["-HUP", "$MAINPID"]},
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with invalid kill mode",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                kill_mode all
+              }
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("invalid %q value for %q directive, at %s:%d", "all", "kill_mode", tf, 4),
+		},
+		{
+			name: "test parse config with invalid stop escalation timeout",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                stop_escalation SIGTERM:soon
+              }
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("invalid %q value for %q directive, at %s:%d", "soon", "stop_escalation", tf, 4),
+		},
+		{
+			name: "test parse config with lifecycle hooks",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                exec_start_pre webapp migrate
+                exec_start_pre webapp check-config
+                exec_start_post curl -X POST http://localhost/registry
+                exec_stop_post rm -rf /tmp/webapp
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"exec_start_pre": [
+					  {"cmd": "webapp", "args": ["migrate"]},
+					  {"cmd": "webapp", "args": ["check-config"]}
+					],
+					"exec_start_post": [
+					  {"cmd": "curl", "args": ["-X", "POST", "http://localhost/registry"]}
+					],
+					"exec_stop_post": [
+					  {"cmd": "rm", "args": ["-rf", "/tmp/webapp"]}
+					],
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with working directory and environment",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                workdir /usr/local/www/webapp
+                cmd webapp
+                clear_env
+                env_file /etc/webapp/.env
+                env PORT 8080
+                env GREETING "Hello, World!"
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"workdir": "/usr/local/www/webapp",
+					"env": {"GREETING": "Hello, World!", "PORT": "8080"},
+					"env_files": ["/etc/webapp/.env"],
+					"clear_env": true,
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with environment passthrough",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              env_passthrough PATH HOME
+              env_passthrough LC_*
+              app webapp {
+                cmd webapp
+                env_passthrough NODE_* TZ
+              }
+            }`),
+			want: `{
+			  "config": {
+                "env_passthrough": ["PATH", "HOME", "LC_*"],
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"env_passthrough": ["NODE_*", "TZ"],
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with user and groups",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                user www-data
+                group www-data
+                supplementary_groups ssl-cert 1001
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"user": "www-data",
+					"group": "www-data",
+					"supplementary_groups": ["ssl-cert", "1001"],
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with resource limits",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                limit_nofile 1024:65536
+                limit_core 0
+                limit_as 2G
+                limit_nproc infinity
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"limits": {
+					  "as": {"soft": 2147483648, "hard": 2147483648},
+					  "core": {"soft": 0, "hard": 0},
+					  "nofile": {"soft": 1024, "hard": 65536},
+					  "nproc": {"soft": 18446744073709551615, "hard": 18446744073709551615}
+					},
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with invalid resource limit",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                limit_nofile 65536:1024
+              }
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("invalid %q value for %q directive, at %s:%d", "65536:1024", "limit_nofile", tf, 4),
+		},
+		{
+			name: "test parse config with cgroup resource controls",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              cgroup_root /sys/fs/cgroup/caddy.service/appd
+              app webapp {
+                cmd webapp
+                memory_max 1G
+                memory_high 768M
+                cpu_max 150%
+                cpu_weight 200
+                pids_max 128
+                io_weight 50
+              }
+              app worker {
+                cmd worker
+                cgroup
+                cpu_max 20ms 50ms
+              }
+            }`),
+			want: `{
+			  "config": {
+                "cgroup_root": "/sys/fs/cgroup/caddy.service/appd",
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"memory_max": 1073741824,
+					"memory_high": 805306368,
+					"cpu_max": {"quota": 150000000, "period": 100000000},
+					"cpu_weight": 200,
+					"pids_max": 128,
+					"io_weight": 50,
+					"seq": 1
+                  },
+                  {
+                    "name":"worker",
+					"cmd":"worker",
+					"kind":"app",
+					"cgroup": true,
+					"cpu_max": {"quota": 20000000, "period": 50000000},
+					"seq": 2
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with invalid cpu weight",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cpu_weight 0
+              }
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("invalid %q value for %q directive, at %s:%d", "0", "cpu_weight", tf, 4),
+		},
+		{
+			name: "test parse config with filesystem sandbox",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                private_tmp
+                protect_system
+                read_only_paths /srv/webapp
+                read_write_paths /srv/webapp/data /etc/webapp
+                inaccessible_paths /var/lib/caddy
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"private_tmp": true,
+					"protect_system": true,
+					"read_only_paths": ["/srv/webapp"],
+					"read_write_paths": ["/srv/webapp/data", "/etc/webapp"],
+					"inaccessible_paths": ["/var/lib/caddy"],
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with private network",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                private_network
+                listen_socket /run/webapp/http.sock
+                listen_socket /run/webapp/admin.sock
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"private_network": true,
+					"listen_sockets": ["/run/webapp/http.sock", "/run/webapp/admin.sock"],
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with capabilities",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                user www-data
+                capabilities CAP_NET_BIND_SERVICE
+                no_new_privileges
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"user": "www-data",
+					"capabilities": ["CAP_NET_BIND_SERVICE"],
+					"no_new_privileges": true,
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with syscall filter",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                syscall_filter @system-service
+                syscall_filter ~@mount ~@reboot ~@module ~ptrace
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"syscall_filter": ["@system-service", "~@mount", "~@reboot", "~@module", "~ptrace"],
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with landlock",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd /usr/local/bin/webapp
+                landlock {
+                  readable /usr /lib /etc/webapp
+                  writable /var/lib/webapp
+                  required
+                }
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"/usr/local/bin/webapp",
+					"kind":"app",
+					"landlock": {
+					  "readable": ["/usr", "/lib", "/etc/webapp"],
+					  "writable": ["/var/lib/webapp"],
+					  "required": true
+					},
+					"seq": 1
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with readiness and health probes",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                cmd webapp
+                ready_interval 250ms
+                ready_probe http http://localhost:8080/health 204
+                ready_timeout 1m
+              }
+              app database {
+                cmd postgres
+                ready_probe exec pg_isready -h localhost
+                health_interval 10s
+                health_probe tcp localhost:5432
+                health_timeout 2s
+                health_failure_threshold 5
+              }
+            }`),
+			want: `{
+			  "config": {
+                "units": [
+                  {
+                    "name":"webapp",
+					"cmd":"webapp",
+					"kind":"app",
+					"ready_probe": {
+					  "kind": "http",
+					  "target": "http://localhost:8080/health",
+					  "expected_status": 204,
+					  "interval": 250000000
+					},
+					"ready_timeout": 60000000000,
+					"seq": 1
+                  },
+                  {
+                    "name":"database",
+					"cmd":"postgres",
+					"kind":"app",
+					"ready_probe": {
+					  "kind": "exec",
+					  "target": "pg_isready",
+					  "args": ["-h", "localhost"]
+					},
+					"health_probe": {
+					  "kind": "tcp",
+					  "target": "localhost:5432",
+					  "interval": 10000000000,
+					  "timeout": 2000000000,
+					  "failure_threshold": 5
+					},
+					"seq": 2
+                  }
+                ]
+              }
+			}`,
+		},
+		{
+			name: "test parse config with invalid readiness probe type",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                ready_probe udp localhost:53
+              }
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("invalid %q probe type for %q directive, at %s:%d", "udp", "ready_probe", tf, 4),
+		},
 		{
 			name: "test parse config with unsupported unit key",
 			d: caddyfile.NewTestDispenser(`
@@ -87,6 +767,19 @@ func TestParseCaddyfile(t *testing.T) {
 			shouldErr: true,
 			err:       fmt.Errorf("unsupported %q key, at %s:%d", "bar", tf, 4),
 		},
+		{
+			name: "test parse config with unsupported landlock key",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              app webapp {
+                landlock {
+                  executable /usr/bin
+                }
+              }
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("unsupported %q key, at %s:%d", "executable", tf, 5),
+		},
 		{
 			name: "test parse config with too few arg for unit arg",
 			d: caddyfile.NewTestDispenser(`
@@ -98,6 +791,17 @@ func TestParseCaddyfile(t *testing.T) {
 			shouldErr: true,
 			err:       fmt.Errorf("too few args for %q directive, at %s:%d", "cmd", tf, 4),
 		},
+		{
+			name: "test parse config with invalid priority",
+			d: caddyfile.NewTestDispenser(`
+            appd {
+              command foo {
+                priority high
+              }
+            }`),
+			shouldErr: true,
+			err:       fmt.Errorf("invalid %q value for %q directive, at %s:%d", "high", "priority", tf, 4),
+		},
 		{
 			name: "test parse config with too many arg for unit arg",
 			d: caddyfile.NewTestDispenser(`
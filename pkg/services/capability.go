@@ -0,0 +1,122 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"strings"
+)
+
+// capabilities are the Linux capabilities, by name without CAP_ prefix.
+var capabilities = map[string]uint{
+	"CHOWN":              0,
+	"DAC_OVERRIDE":       1,
+	"DAC_READ_SEARCH":    2,
+	"FOWNER":             3,
+	"FSETID":             4,
+	"KILL":               5,
+	"SETGID":             6,
+	"SETUID":             7,
+	"SETPCAP":            8,
+	"LINUX_IMMUTABLE":    9,
+	"NET_BIND_SERVICE":   10,
+	"NET_BROADCAST":      11,
+	"NET_ADMIN":          12,
+	"NET_RAW":            13,
+	"IPC_LOCK":           14,
+	"IPC_OWNER":          15,
+	"SYS_MODULE":         16,
+	"SYS_RAWIO":          17,
+	"SYS_CHROOT":         18,
+	"SYS_PTRACE":         19,
+	"SYS_PACCT":          20,
+	"SYS_ADMIN":          21,
+	"SYS_BOOT":           22,
+	"SYS_NICE":           23,
+	"SYS_RESOURCE":       24,
+	"SYS_TIME":           25,
+	"SYS_TTY_CONFIG":     26,
+	"MKNOD":              27,
+	"LEASE":              28,
+	"AUDIT_WRITE":        29,
+	"AUDIT_CONTROL":      30,
+	"SETFCAP":            31,
+	"MAC_OVERRIDE":       32,
+	"MAC_ADMIN":          33,
+	"SYSLOG":             34,
+	"WAKE_ALARM":         35,
+	"BLOCK_SUSPEND":      36,
+	"AUDIT_READ":         37,
+	"PERFMON":            38,
+	"BPF":                39,
+	"CHECKPOINT_RESTORE": 40,
+}
+
+// The bits of the capabilities the launcher requires.
+var (
+	capSetGID   = capabilities["SETGID"]
+	capSetUID   = capabilities["SETUID"]
+	capSetPCAP  = capabilities["SETPCAP"]
+	capSysAdmin = capabilities["SYS_ADMIN"]
+)
+
+// parseCapability returns the bit of the capability. The name is case
+// insensitive, with or without CAP_ prefix, e.g. CAP_NET_BIND_SERVICE.
+func parseCapability(s string) (uint, error) {
+	name := strings.TrimPrefix(strings.ToUpper(s), "CAP_")
+	if c, exists := capabilities[name]; exists {
+		return c, nil
+	}
+	return 0, fmt.Errorf("unsupported %q capability", s)
+}
+
+// capabilityBits returns the bits of the capabilities of the unit.
+func (u *Unit) capabilityBits() []uint {
+	var bits []uint
+	for _, s := range u.Capabilities {
+		if c, err := parseCapability(s); err == nil {
+			bits = append(bits, c)
+		}
+	}
+	return bits
+}
+
+// validateCapabilities checks the capabilities of the unit. It returns an
+// error when the current process lacks any of them, or the privileges to
+// drop the others.
+func (u *Unit) validateCapabilities() error {
+	if len(u.Capabilities) == 0 && !u.NoNewPrivileges {
+		return nil
+	}
+	if !capabilitiesSupported {
+		return fmt.Errorf("capabilities and no_new_privileges are not supported on this platform")
+	}
+	for _, s := range u.Capabilities {
+		c, err := parseCapability(s)
+		if err != nil {
+			return err
+		}
+		if c > lastCapability() {
+			return fmt.Errorf("capability %s is not supported by the kernel", s)
+		}
+		if !hasCapabilities(c) {
+			return fmt.Errorf("insufficient privileges to retain capability %s", s)
+		}
+	}
+	if len(u.Capabilities) > 0 && !hasCapabilities(capSetPCAP) {
+		return fmt.Errorf("insufficient privileges to drop capabilities")
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const capabilitiesSupported = true
+
+// hasCapabilities returns true when the current process has all the
+// effective capabilities.
+func hasCapabilities(bits ...uint) bool {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		v, found := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !found {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		if err != nil {
+			return false
+		}
+		for _, bit := range bits {
+			if caps&(1<<bit) == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// lastCapability returns the bit of the last capability the kernel
+// supports.
+func lastCapability() uint {
+	// The kernels prior to 3.2 lack cap_last_cap.
+	b, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return capabilities["AUDIT_READ"]
+	}
+	n, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 32)
+	if err != nil {
+		return capabilities["AUDIT_READ"]
+	}
+	return uint(n)
+}
+
+// The capabilities, no_new_privs, and the bounding set are the attributes
+// of a thread. The launcher applies them to the thread executing the
+// command.
+
+// dropCapabilities removes the capabilities other than the kept ones from
+// the bounding set, and makes the thread retain the kept ones when
+// switching to a non-root user.
+func dropCapabilities(keep []uint) error {
+	kept := make(map[uint]bool)
+	for _, c := range keep {
+		kept[c] = true
+	}
+	for c := uint(0); c <= lastCapability(); c++ {
+		if kept[c] {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			return fmt.Errorf("failed dropping capability %d: %w", c, err)
+		}
+	}
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed retaining capabilities: %w", err)
+	}
+	return nil
+}
+
+// raiseCapabilities sets the effective, permitted, and inheritable
+// capabilities of the thread to the kept ones, and raises them in the
+// ambient set, which the command inherits regardless of its user.
+func raiseCapabilities(keep []uint) error {
+	hdr := &unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	for _, c := range keep {
+		data[c/32].Effective |= 1 << (c % 32)
+		data[c/32].Permitted |= 1 << (c % 32)
+		data[c/32].Inheritable |= 1 << (c % 32)
+	}
+	if err := unix.Capset(hdr, &data[0]); err != nil {
+		return fmt.Errorf("failed setting capabilities: %w", err)
+	}
+	for _, c := range keep {
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(c), 0, 0); err != nil {
+			return fmt.Errorf("failed raising ambient capability %d: %w", c, err)
+		}
+	}
+	return nil
+}
+
+// setNoNewPrivileges prevents the command from gaining privileges, e.g.
+// via setuid executables.
+func setNoNewPrivileges() error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed setting no_new_privs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestParseCapability(t *testing.T) {
+	testcases := []struct {
+		name      string
+		input     string
+		want      uint
+		shouldErr bool
+	}{
+		{name: "test capability with prefix", input: "CAP_NET_BIND_SERVICE", want: 10},
+		{name: "test capability without prefix", input: "net_raw", want: 13},
+		{name: "test unsupported capability", input: "CAP_FLY", shouldErr: true},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCapability(tc.input)
+			if (err != nil) != tc.shouldErr {
+				t.Fatalf("unexpected result: %v, want error: %v", err, tc.shouldErr)
+			}
+			if got != tc.want {
+				t.Errorf("unexpected capability: %d, want: %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateCapabilities(t *testing.T) {
+	unit := &Unit{
+		Name:         "webapp",
+		Kind:         "app",
+		Command:      "webapp",
+		Capabilities: []string{"CAP_NET_BIND_SERVICE", "CAP_FLY"},
+	}
+	err := unit.validate()
+	want := `unit "webapp": unsupported "CAP_FLY" capability`
+	if !capabilitiesSupported {
+		want = `unit "webapp": capabilities and no_new_privileges are not supported on this platform`
+	}
+	if err == nil || err.Error() != want {
+		t.Fatalf("unexpected error: %v, want: %v", err, want)
+	}
+}
+
+func TestServiceCapabilities(t *testing.T) {
+	if !capabilitiesSupported || os.Geteuid() != 0 {
+		t.Skip("requires capabilities and root privileges")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("requires nobody user")
+	}
+	unit := &Unit{
+		Name:            "webapp",
+		Kind:            "command",
+		Command:         "grep",
+		Arguments:       []string{"-E", "^(CapEff|CapBnd|CapAmb|NoNewPrivs)", "/proc/self/status"},
+		WorkDirectory:   "/",
+		User:            "nobody",
+		Capabilities:    []string{"CAP_NET_BIND_SERVICE"},
+		NoNewPrivileges: true,
+		StdOutFilePath:  filepath.Join(t.TempDir(), "stdout.log"),
+	}
+	if err := unit.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := unit.resolveCredential(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	b, err := os.ReadFile(unit.StdOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"NoNewPrivs:\t1",
+		"CapEff:\t0000000000000400",
+		"CapBnd:\t0000000000000400",
+		"CapAmb:\t0000000000000400",
+	}
+	for _, line := range want {
+		if !strings.Contains(string(b), line) {
+			t.Errorf("expected %q in process status:\n%s", line, b)
+		}
+	}
+}
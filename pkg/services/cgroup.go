@@ -0,0 +1,211 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCPUMaxPeriod = 100 * time.Millisecond
+	minCPUMaxPeriod     = time.Millisecond
+	maxCPUMaxPeriod     = time.Second
+	maxCgroupWeight     = 10000
+)
+
+// CPUMax is the CPU bandwidth limit of a unit, i.e. the CPU time the
+// processes of the unit may use within each period.
+type CPUMax struct {
+	Quota  Duration `json:"quota"`
+	Period Duration `json:"period,omitempty"`
+}
+
+// Usage is the resource usage of a unit accounted by its cgroup.
+type Usage struct {
+	// The memory used by the processes of the unit, in bytes.
+	MemoryCurrent uint64 `json:"memory_current,omitempty"`
+	// The CPU time used by the processes of the unit.
+	CPUUsage  Duration `json:"cpu_usage,omitempty"`
+	CPUUser   Duration `json:"cpu_user,omitempty"`
+	CPUSystem Duration `json:"cpu_system,omitempty"`
+	// The number of the processes of the unit.
+	Processes int `json:"processes,omitempty"`
+}
+
+// ParseByteSize parses the size in bytes with optional K, M, G, or T
+// suffix, e.g. 512M.
+func ParseByteSize(s string) (uint64, error) {
+	multiplier := uint64(1)
+	if s != "" {
+		if i := strings.IndexByte("KMGT", s[len(s)-1]); i >= 0 {
+			multiplier = 1 << (10 * (i + 1))
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n > math.MaxUint64/multiplier {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return n * multiplier, nil
+}
+
+// ParseCPUMax parses the CPU bandwidth limit. The limit is either the
+// percentage of a single CPU, e.g. 150%, or the quota and the optional
+// period durations, e.g. 50ms 100ms.
+func ParseCPUMax(args []string) (*CPUMax, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return nil, fmt.Errorf("invalid cpu max: %v", args)
+	}
+	if s, found := strings.CutSuffix(args[0], "%"); found {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("invalid cpu max: %v", args)
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid cpu max percentage: %q", args[0])
+		}
+		quota := time.Duration(n * float64(defaultCPUMaxPeriod) / 100)
+		return &CPUMax{Quota: Duration(quota), Period: Duration(defaultCPUMaxPeriod)}, nil
+	}
+	limit := &CPUMax{}
+	for i, s := range args {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu max duration: %q", s)
+		}
+		if i == 0 {
+			limit.Quota = Duration(d)
+		} else {
+			limit.Period = Duration(d)
+		}
+	}
+	return limit, nil
+}
+
+func (c *CPUMax) period() time.Duration {
+	if c.Period == 0 {
+		return defaultCPUMaxPeriod
+	}
+	return time.Duration(c.Period)
+}
+
+// String returns the limit in the format of cpu.max file.
+func (c *CPUMax) String() string {
+	return fmt.Sprintf("%d %d", time.Duration(c.Quota).Microseconds(), c.period().Microseconds())
+}
+
+// cgroupEnabled returns true when the processes of the unit run in the
+// cgroup of the unit.
+func (u *Unit) cgroupEnabled() bool {
+	return u.Cgroup || len(u.cgroupControllers()) > 0
+}
+
+// cgroupControllers returns the cgroup controllers the resource controls
+// of the unit require.
+func (u *Unit) cgroupControllers() []string {
+	var controllers []string
+	if u.CPUMax != nil || u.CPUWeight > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if u.IOWeight > 0 {
+		controllers = append(controllers, "io")
+	}
+	if u.MemoryMax > 0 || u.MemoryHigh > 0 {
+		controllers = append(controllers, "memory")
+	}
+	if u.PidsMax > 0 {
+		controllers = append(controllers, "pids")
+	}
+	return controllers
+}
+
+// cgroupSettings returns the values of the resource controls of the
+// unit, by cgroup file name.
+func (u *Unit) cgroupSettings() map[string]string {
+	m := make(map[string]string)
+	if u.MemoryMax > 0 {
+		m["memory.max"] = strconv.FormatUint(u.MemoryMax, 10)
+	}
+	if u.MemoryHigh > 0 {
+		m["memory.high"] = strconv.FormatUint(u.MemoryHigh, 10)
+	}
+	if u.CPUMax != nil {
+		m["cpu.max"] = u.CPUMax.String()
+	}
+	if u.CPUWeight > 0 {
+		m["cpu.weight"] = strconv.FormatUint(u.CPUWeight, 10)
+	}
+	if u.PidsMax > 0 {
+		m["pids.max"] = strconv.FormatUint(u.PidsMax, 10)
+	}
+	if u.IOWeight > 0 {
+		m["io.weight"] = "default " + strconv.FormatUint(u.IOWeight, 10)
+	}
+	return m
+}
+
+// validateCgroup checks the resource controls of the unit.
+func (u *Unit) validateCgroup() error {
+	if !u.cgroupEnabled() {
+		return nil
+	}
+	if !cgroupSupported {
+		return fmt.Errorf("cgroups are not supported on this platform")
+	}
+	if u.MemoryMax > 0 && u.MemoryHigh > u.MemoryMax {
+		return fmt.Errorf("memory high exceeds memory max")
+	}
+	if c := u.CPUMax; c != nil {
+		if time.Duration(c.Quota) < minCPUMaxPeriod {
+			return fmt.Errorf("cpu max quota is less than %s", minCPUMaxPeriod)
+		}
+		if p := c.period(); p < minCPUMaxPeriod || p > maxCPUMaxPeriod {
+			return fmt.Errorf("cpu max period is not between %s and %s", minCPUMaxPeriod, maxCPUMaxPeriod)
+		}
+	}
+	weights := map[string]uint64{"cpu": u.CPUWeight, "io": u.IOWeight}
+	for _, k := range []string{"cpu", "io"} {
+		if weights[k] > maxCgroupWeight {
+			return fmt.Errorf("%s weight is not between 1 and %d", k, maxCgroupWeight)
+		}
+	}
+	return nil
+}
+
+// resolveCgroup sets the path to the cgroup of the unit in the parent
+// cgroup. When the parent is empty, it is the cgroup of the current
+// process.
+func (u *Unit) resolveCgroup(parent string) error {
+	u.CgroupPath = ""
+	if !u.cgroupEnabled() {
+		return nil
+	}
+	if parent == "" {
+		var err error
+		if parent, err = currentCgroup(); err != nil {
+			return fmt.Errorf("unit %q: %w", u.Name, err)
+		}
+	}
+	if err := checkCgroupParent(parent, u.cgroupControllers()); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	u.CgroupPath = filepath.Join(parent, u.Name)
+	return nil
+}
@@ -0,0 +1,279 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const cgroupSupported = true
+
+// currentCgroup returns the path to the cgroup of the current process in
+// the cgroup v2 hierarchy.
+func currentCgroup() (string, error) {
+	mountPoint, err := cgroupMountPoint()
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		if p, found := strings.CutPrefix(line, "0::"); found {
+			return filepath.Join(mountPoint, p), nil
+		}
+	}
+	return "", fmt.Errorf("cgroup v2 membership not found")
+}
+
+// cgroupMountPoint returns the mount point of the cgroup v2 hierarchy.
+func cgroupMountPoint() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The fields following the separator are the filesystem type,
+		// the source, and the super block options.
+		pre, post, found := strings.Cut(scanner.Text(), " - ")
+		if !found || !strings.HasPrefix(post, "cgroup2 ") {
+			continue
+		}
+		if fields := strings.Fields(pre); len(fields) > 4 {
+			return fields[4], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("cgroup v2 hierarchy is not mounted")
+}
+
+// checkCgroupParent verifies that the parent cgroup is in the cgroup v2
+// hierarchy, provides the controllers, and permits creating cgroups in it.
+func checkCgroupParent(parent string, controllers []string) error {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(parent, &fs); err != nil {
+		return fmt.Errorf("cgroup %s: %w", parent, err)
+	}
+	if fs.Type != unix.CGROUP2_SUPER_MAGIC {
+		return fmt.Errorf("cgroup %s is not in cgroup v2 hierarchy", parent)
+	}
+	b, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("cgroup %s: %w", parent, err)
+	}
+	available := strings.Fields(string(b))
+	for _, controller := range controllers {
+		if !slices.Contains(available, controller) {
+			return fmt.Errorf("cgroup controller %q is not available in %s", controller, parent)
+		}
+	}
+	if err := unix.Access(parent, unix.W_OK); err != nil {
+		return fmt.Errorf("insufficient privileges to create cgroups in %s", parent)
+	}
+	if len(controllers) > 0 && cgroupHasProcesses(parent) {
+		b, err := os.ReadFile(filepath.Join(parent, "cgroup.subtree_control"))
+		if err != nil {
+			return fmt.Errorf("cgroup %s: %w", parent, err)
+		}
+		enabled := strings.Fields(string(b))
+		for _, controller := range controllers {
+			if !slices.Contains(enabled, controller) {
+				return fmt.Errorf("cgroup %s has processes, which prevents enabling cgroup controllers, set cgroup_root to a delegated cgroup without processes", parent)
+			}
+		}
+	}
+	return nil
+}
+
+// cgroupHasProcesses returns true when the processes in the cgroup
+// prevent enabling the controllers for its children. The root cgroup is
+// exempt from the rule, and lacks cgroup.type file.
+func cgroupHasProcesses(path string) bool {
+	if _, err := os.Stat(filepath.Join(path, "cgroup.type")); err != nil {
+		return false
+	}
+	b, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+	return err == nil && len(strings.TrimSpace(string(b))) > 0
+}
+
+// cgroup is the cgroup the processes of a unit run in.
+type cgroup struct {
+	path string
+	// The directory of the cgroup, open while starting a process in it.
+	dir *os.File
+}
+
+// cgroupSeq numbers the cgroups of the units.
+var cgroupSeq atomic.Uint64
+
+// openCgroup creates a new cgroup for a process of the unit, enables the
+// controllers the unit requires in the parent cgroup, and applies the
+// resource controls of the unit. Each process gets its own cgroup, e.g.
+// the app starting on a config reload while the previous one stops, so
+// that stopping one of them does not affect the other.
+func openCgroup(unit *Unit) (*cgroup, error) {
+	if controllers := unit.cgroupControllers(); len(controllers) > 0 {
+		var s []string
+		for _, controller := range controllers {
+			s = append(s, "+"+controller)
+		}
+		fp := filepath.Join(filepath.Dir(unit.CgroupPath), "cgroup.subtree_control")
+		if err := os.WriteFile(fp, []byte(strings.Join(s, " ")), 0); err != nil {
+			return nil, fmt.Errorf("failed enabling cgroup controllers: %w", err)
+		}
+	}
+	var path string
+	for {
+		// The cgroups of the previous instances of Caddy may remain.
+		path = fmt.Sprintf("%s-%d", unit.CgroupPath, cgroupSeq.Add(1))
+		err := os.Mkdir(path, 0755)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed creating cgroup: %w", err)
+		}
+	}
+	cg := &cgroup{path: path}
+	for k, v := range unit.cgroupSettings() {
+		if err := os.WriteFile(filepath.Join(path, k), []byte(v), 0); err != nil {
+			cg.remove()
+			return nil, fmt.Errorf("failed setting cgroup %s: %w", k, err)
+		}
+	}
+	dir, err := os.Open(path)
+	if err != nil {
+		cg.remove()
+		return nil, fmt.Errorf("failed opening cgroup: %w", err)
+	}
+	cg.dir = dir
+	return cg, nil
+}
+
+// place makes the command start in the cgroup.
+func (cg *cgroup) place(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(cg.dir.Fd())
+}
+
+// started closes the directory of the cgroup after starting a process.
+func (cg *cgroup) started() {
+	cg.dir.Close()
+}
+
+// pids returns the PIDs of the processes in the cgroup.
+func (cg *cgroup) pids() ([]int, error) {
+	b, err := os.ReadFile(filepath.Join(cg.path, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, s := range strings.Fields(string(b)) {
+		if pid, err := strconv.Atoi(s); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// alive returns true when any process remains in the cgroup.
+func (cg *cgroup) alive() bool {
+	pids, err := cg.pids()
+	return err == nil && len(pids) > 0
+}
+
+// signal sends the signal to the processes in the cgroup.
+func (cg *cgroup) signal(sig os.Signal) error {
+	pids, err := cg.pids()
+	if err != nil {
+		return err
+	}
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal: %v", sig)
+	}
+	for _, pid := range pids {
+		if err := syscall.Kill(pid, s); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
+
+// kill kills the processes in the cgroup. The kernels prior to 5.14 lack
+// cgroup.kill, and the processes receive SIGKILL one by one.
+func (cg *cgroup) kill() error {
+	if err := os.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0); err == nil {
+		return nil
+	}
+	return cg.signal(syscall.SIGKILL)
+}
+
+// remove removes the cgroup, unless processes remain in it.
+func (cg *cgroup) remove() {
+	os.Remove(cg.path)
+}
+
+// readUsage returns the resource usage accounted by the cgroup at the
+// path.
+func readUsage(path string) (*Usage, error) {
+	cg := &cgroup{path: path}
+	pids, err := cg.pids()
+	if err != nil {
+		return nil, err
+	}
+	usage := &Usage{Processes: len(pids)}
+	if b, err := os.ReadFile(filepath.Join(path, "memory.current")); err == nil {
+		usage.MemoryCurrent, _ = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	}
+	b, err := os.ReadFile(filepath.Join(path, "cpu.stat"))
+	if err != nil {
+		return usage, nil
+	}
+	stats := map[string]*Duration{
+		"usage_usec":  &usage.CPUUsage,
+		"user_usec":   &usage.CPUUser,
+		"system_usec": &usage.CPUSystem,
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		k, v, _ := strings.Cut(line, " ")
+		if d, exists := stats[k]; exists {
+			n, _ := strconv.ParseInt(v, 10, 64)
+			*d = Duration(time.Duration(n) * time.Microsecond)
+		}
+	}
+	return usage, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package services
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// cgroups are not supported on the platforms other than Linux.
+const cgroupSupported = false
+
+func currentCgroup() (string, error) {
+	return "", fmt.Errorf("cgroups are not supported on this platform")
+}
+
+func checkCgroupParent(parent string, controllers []string) error {
+	return fmt.Errorf("cgroups are not supported on this platform")
+}
+
+func cgroupHasProcesses(path string) bool {
+	return false
+}
+
+type cgroup struct {
+	path string
+}
+
+func openCgroup(unit *Unit) (*cgroup, error) {
+	return nil, fmt.Errorf("cgroups are not supported on this platform")
+}
+
+func (cg *cgroup) place(cmd *exec.Cmd)        {}
+func (cg *cgroup) started()                   {}
+func (cg *cgroup) alive() bool                { return false }
+func (cg *cgroup) signal(sig os.Signal) error { return nil }
+func (cg *cgroup) kill() error                { return nil }
+func (cg *cgroup) remove()                    {}
+
+func readUsage(path string) (*Usage, error) {
+	return nil, fmt.Errorf("cgroups are not supported on this platform")
+}
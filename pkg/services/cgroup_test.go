@@ -0,0 +1,298 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+)
+
+func TestParseCPUMax(t *testing.T) {
+	testcases := []struct {
+		name      string
+		args      []string
+		want      string
+		shouldErr bool
+	}{
+		{
+			name: "test percentage",
+			args: []string{"150%"},
+			want: "150000 100000",
+		},
+		{
+			name: "test quota",
+			args: []string{"20ms"},
+			want: "20000 100000",
+		},
+		{
+			name: "test quota and period",
+			args: []string{"50ms", "250ms"},
+			want: "50000 250000",
+		},
+		{
+			name:      "test invalid percentage",
+			args:      []string{"-5%"},
+			shouldErr: true,
+		},
+		{
+			name:      "test percentage with period",
+			args:      []string{"50%", "100ms"},
+			shouldErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseCPUMax(tc.args)
+			if (err != nil) != tc.shouldErr {
+				t.Fatalf("unexpected result: %v, want error: %v", err, tc.shouldErr)
+			}
+			if err == nil && got.String() != tc.want {
+				t.Errorf("unexpected cpu max: %q, want: %q", got.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestValidateCgroup(t *testing.T) {
+	testcases := []struct {
+		name string
+		unit *Unit
+		err  string
+	}{
+		{
+			name: "test memory high exceeding memory max",
+			unit: &Unit{MemoryMax: 1 << 20, MemoryHigh: 2 << 20},
+			err:  "memory high exceeds memory max",
+		},
+		{
+			name: "test cpu max quota below minimum",
+			unit: &Unit{CPUMax: &CPUMax{Quota: Duration(time.Microsecond)}},
+			err:  "cpu max quota is less than 1ms",
+		},
+		{
+			name: "test cpu max period above maximum",
+			unit: &Unit{CPUMax: &CPUMax{Quota: Duration(time.Second), Period: Duration(2 * time.Second)}},
+			err:  "cpu max period is not between 1ms and 1s",
+		},
+		{
+			name: "test io weight out of range",
+			unit: &Unit{IOWeight: 20000},
+			err:  "io weight is not between 1 and 10000",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.unit.validateCgroup()
+			if err == nil || err.Error() != tc.err {
+				t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCgroupSettings(t *testing.T) {
+	unit := &Unit{
+		MemoryMax:  512 << 20,
+		MemoryHigh: 384 << 20,
+		CPUMax:     &CPUMax{Quota: Duration(50 * time.Millisecond)},
+		CPUWeight:  200,
+		PidsMax:    64,
+		IOWeight:   50,
+	}
+	want := map[string]string{
+		"memory.max":  "536870912",
+		"memory.high": "402653184",
+		"cpu.max":     "50000 100000",
+		"cpu.weight":  "200",
+		"pids.max":    "64",
+		"io.weight":   "default 50",
+	}
+	if diff := cmp.Diff(want, unit.cgroupSettings()); diff != "" {
+		t.Errorf("cgroupSettings() mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"cpu", "io", "memory", "pids"}, unit.cgroupControllers()); diff != "" {
+		t.Errorf("cgroupControllers() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestServiceCgroup(t *testing.T) {
+	if !cgroupSupported || os.Geteuid() != 0 {
+		t.Skip("requires cgroups and root privileges")
+	}
+	pidFilePath := filepath.Join(t.TempDir(), "escaped.pid")
+	unit := &Unit{
+		Name:    "appd-test-cgroup",
+		Kind:    "app",
+		Command: "sh",
+		// The background process escapes the process group of the app.
+		Arguments:  []string{"-c", "setsid sleep 60 & echo $! > " + pidFilePath + "; exec sleep 60"},
+		Cgroup:     true,
+		StopSignal: "SIGTERM",
+		ReadyProbe: &Probe{
+			Kind:      ExecProbe,
+			Target:    "test",
+			Arguments: []string{"-s", pidFilePath},
+			Interval:  Duration(20 * time.Millisecond),
+		},
+	}
+	if err := unit.resolveCgroup(""); err != nil {
+		t.Skipf("requires delegated cgroup: %v", err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	cgroupPath := svc.worker.cgroup.path
+	status := svc.GetStatus()
+	if status.Usage == nil || status.Usage.Processes != 2 {
+		t.Fatalf("unexpected usage: %+v", status.Usage)
+	}
+	b, err := os.ReadFile(pidFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(b)))
+
+	if err := svc.Stop(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	// The escaped process leads its own process group.
+	if groupAlive(pid) {
+		t.Errorf("expected killed process %d", pid)
+	}
+	if _, err := os.Stat(cgroupPath); !os.IsNotExist(err) {
+		t.Errorf("expected removed cgroup %s, got: %v", cgroupPath, err)
+	}
+	if status := svc.GetStatus(); status.Usage != nil {
+		t.Errorf("unexpected usage of stopped app: %+v", status.Usage)
+	}
+}
+
+func TestServiceCgroupInstances(t *testing.T) {
+	if !cgroupSupported || os.Geteuid() != 0 {
+		t.Skip("requires cgroups and root privileges")
+	}
+	unit := &Unit{
+		Name:       "appd-test-cgroup-instances",
+		Kind:       "app",
+		Command:    "sleep",
+		Arguments:  []string{"60"},
+		Cgroup:     true,
+		StopSignal: "SIGTERM",
+	}
+	if err := unit.resolveCgroup(""); err != nil {
+		t.Skipf("requires delegated cgroup: %v", err)
+	}
+	// The instances of the app overlap on a config reload.
+	var svcs []*Service
+	for i := 0; i < 2; i++ {
+		svc, err := NewService(i, unit, zap.NewNop())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := svc.Start(); err != nil {
+			t.Fatalf("expected success, got: %v", err)
+		}
+		defer svc.Stop()
+		svcs = append(svcs, svc)
+	}
+	previous, current := svcs[0], svcs[1]
+	if previous.worker.cgroup.path == current.worker.cgroup.path {
+		t.Fatalf("expected separate cgroups, got: %s", current.worker.cgroup.path)
+	}
+	if err := previous.Stop(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if st := current.GetState(); st.Current != RunningState {
+		t.Fatalf("unexpected state of current app: %v", st.Current)
+	}
+	if status := current.GetStatus(); status.Usage == nil || status.Usage.Processes != 1 {
+		t.Errorf("unexpected usage of current app: %+v", status.Usage)
+	}
+}
+
+func TestResolveCgroupWithProcesses(t *testing.T) {
+	if !cgroupSupported || os.Geteuid() != 0 {
+		t.Skip("requires cgroups and root privileges")
+	}
+	current, err := currentCgroup()
+	if err != nil || !cgroupHasProcesses(current) {
+		t.Skip("requires non-root cgroup v2 membership")
+	}
+	if err := checkCgroupParent(current, nil); err != nil {
+		t.Skipf("requires delegated cgroup: %v", err)
+	}
+	unit := &Unit{Name: "webapp", Kind: "app", Command: "webapp", PidsMax: 64}
+	err = unit.resolveCgroup("")
+	if err == nil || !strings.Contains(err.Error(), "has processes") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServiceCgroupLimits(t *testing.T) {
+	if !cgroupSupported || os.Geteuid() != 0 {
+		t.Skip("requires cgroups and root privileges")
+	}
+	current, err := currentCgroup()
+	if err != nil {
+		t.Skipf("requires cgroup v2: %v", err)
+	}
+	// The cgroup of the test process may have processes, so the cgroup of
+	// the unit resides in a child cgroup without processes.
+	root := filepath.Join(current, "appd-test-root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Skipf("requires delegated cgroup: %v", err)
+	}
+	defer os.Remove(root)
+
+	unit := &Unit{
+		Name:       "appd-test-cgroup-limits",
+		Kind:       "app",
+		Command:    "sleep",
+		Arguments:  []string{"60"},
+		PidsMax:    64,
+		StopSignal: "SIGTERM",
+	}
+	if err := unit.resolveCgroup(root); err != nil {
+		t.Skipf("requires delegated cgroup with pids controller: %v", err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	defer svc.Stop()
+
+	b, err := os.ReadFile(filepath.Join(svc.worker.cgroup.path, "pids.max"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(b)); got != "64" {
+		t.Errorf("unexpected pids.max: %q, want: %q", got, "64")
+	}
+}
@@ -17,12 +17,27 @@ package services
 import (
 	"fmt"
 	"sort"
+	"strings"
 )
 
 // Config is a configuration of Manager.
 type Config struct {
-	Units   []*Unit `json:"units,omitempty"`
+	Units []*Unit `json:"units,omitempty"`
+	// The maximum number of units starting concurrently. If zero, all the
+	// units without dependencies between them start concurrently.
+	MaxParallelStarts int `json:"max_parallel_starts,omitempty"`
+	// The upper bound of the random delay prior to the start of a unit.
+	StartJitter Duration `json:"start_jitter,omitempty"`
+	// The glob patterns of the names of the environment variables all the
+	// units inherit from Caddy.
+	EnvPassthrough []string `json:"env_passthrough,omitempty"`
+	// The path to the cgroup v2 directory the cgroups of the units are
+	// created in. Defaults to the cgroup of Caddy.
+	CgroupRoot string `json:"cgroup_root,omitempty"`
+
 	unitMap map[string]*Unit
+	// The depth of a unit in the dependency graph.
+	depths map[string]int
 }
 
 // NewConfig returns an instance of Config.
@@ -43,26 +58,205 @@ func (cfg *Config) AddUnit(u *Unit) error {
 	return nil
 }
 
+func (cfg *Config) index() error {
+	cfg.unitMap = make(map[string]*Unit)
+	for _, u := range cfg.Units {
+		if _, exists := cfg.unitMap[u.Name]; exists {
+			return fmt.Errorf("unit %q already exists", u.Name)
+		}
+		cfg.unitMap[u.Name] = u
+	}
+	return nil
+}
+
 func (cfg *Config) validate() error {
+	if err := cfg.index(); err != nil {
+		return err
+	}
+	for _, pattern := range cfg.EnvPassthrough {
+		if err := validateEnvPattern(pattern); err != nil {
+			return err
+		}
+	}
 	for _, u := range cfg.Units {
-		for _, dep := range u.Before {
-			if _, exists := cfg.unitMap[dep]; !exists {
-				return fmt.Errorf("the %q in %q directive for unit %q is not found", dep, "before", u.Name)
+		u.GlobalEnvPassthrough = cfg.EnvPassthrough
+		if err := u.validate(); err != nil {
+			return err
+		}
+		if err := u.resolveCredential(); err != nil {
+			return err
+		}
+		if err := u.resolveCgroup(cfg.CgroupRoot); err != nil {
+			return err
+		}
+		deps := map[string][]string{
+			"before":   u.Before,
+			"after":    u.After,
+			"wants":    u.Wants,
+			"requires": u.Requires,
+		}
+		for _, k := range []string{"before", "after", "wants", "requires"} {
+			for _, dep := range deps[k] {
+				if _, exists := cfg.unitMap[dep]; !exists {
+					return fmt.Errorf("the %q in %q directive for unit %q is not found", dep, k, u.Name)
+				}
 			}
 		}
-		for _, dep := range u.After {
-			if _, exists := cfg.unitMap[dep]; !exists {
-				return fmt.Errorf("the %q in %q directive for unit %q is not found", dep, "after", u.Name)
+		// The noop units never become active, so the units requiring them
+		// would never start.
+		for _, dep := range u.Requires {
+			if cfg.unitMap[dep].Noop && !u.Noop {
+				return fmt.Errorf("the %q in %q directive for unit %q is a noop unit", dep, "requires", u.Name)
 			}
 		}
 	}
 	return nil
 }
 
+// edges returns the names of the units that must start after the
+// provided unit, i.e. the units in its Before directive and the units
+// having it in their After, Wants, or Requires directives.
+func (cfg *Config) edges() map[string][]string {
+	m := make(map[string][]string)
+	seen := make(map[string]bool)
+	add := func(from, to string) {
+		k := from + "\x00" + to
+		if seen[k] {
+			return
+		}
+		seen[k] = true
+		m[from] = append(m[from], to)
+	}
+	for _, u := range cfg.Units {
+		for _, dep := range u.Before {
+			add(u.Name, dep)
+		}
+		for _, deps := range [][]string{u.After, u.Wants, u.Requires} {
+			for _, dep := range deps {
+				add(dep, u.Name)
+			}
+		}
+	}
+	return m
+}
+
+// order sorts the units topologically based on their Before, After, Wants,
+// and Requires directives. When multiple units are ready to start, the unit with the
+// higher Priority starts first. The units with the same Priority start in
+// alphabetical order. The units with the same depth in the dependency graph
+// do not depend on each other and may start concurrently.
 func (cfg *Config) order() error {
+	cfg.unitOrderAsc()
+
+	edges := cfg.edges()
+	inDegree := make(map[string]int)
+	for _, deps := range edges {
+		for _, dep := range deps {
+			inDegree[dep]++
+		}
+	}
+
+	var ready []*Unit
+	for _, u := range cfg.Units {
+		if inDegree[u.Name] == 0 {
+			ready = append(ready, u)
+		}
+	}
+
+	var units []*Unit
+	for len(ready) > 0 {
+		sortUnits(ready)
+		u := ready[0]
+		ready = ready[1:]
+		units = append(units, u)
+		for _, dep := range edges[u.Name] {
+			inDegree[dep]--
+			if inDegree[dep] == 0 {
+				ready = append(ready, cfg.unitMap[dep])
+			}
+		}
+	}
+
+	if len(units) != len(cfg.Units) {
+		return cfg.cycleError(edges, inDegree)
+	}
+
+	depths := make(map[string]int)
+	for _, u := range units {
+		for _, dep := range edges[u.Name] {
+			if depths[u.Name]+1 > depths[dep] {
+				depths[dep] = depths[u.Name] + 1
+			}
+		}
+	}
+
+	cfg.Units = units
+	cfg.depths = depths
 	return nil
 }
 
+// cycleError returns an error describing a dependency cycle among the units
+// that the topological sort was unable to order.
+func (cfg *Config) cycleError(edges map[string][]string, inDegree map[string]int) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int)
+	var path []string
+	var cycle []string
+
+	var visit func(string) bool
+	visit = func(name string) bool {
+		marks[name] = visiting
+		path = append(path, name)
+		for _, dep := range edges[name] {
+			switch marks[dep] {
+			case visiting:
+				for i, n := range path {
+					if n == dep {
+						cycle = append(append(cycle, path[i:]...), dep)
+						return true
+					}
+				}
+			case unvisited:
+				if visit(dep) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		marks[name] = visited
+		return false
+	}
+
+	for _, u := range cfg.Units {
+		if inDegree[u.Name] == 0 || marks[u.Name] != unvisited {
+			continue
+		}
+		if visit(u.Name) {
+			return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	return fmt.Errorf("dependency cycle detected")
+}
+
+func sortUnits(units []*Unit) {
+	sort.SliceStable(units, func(a, b int) bool {
+		return unitLess(units[a], units[b])
+	})
+}
+
+// unitLess returns true when the unit a starts prior to the unit b, once
+// both are ready to start.
+func unitLess(a, b *Unit) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.Name < b.Name
+}
+
 func (cfg *Config) unitOrderAsc() error {
 	asc := func(a, b int) bool {
 		return cfg.Units[a].Seq < cfg.Units[b].Seq
@@ -16,6 +16,7 @@ package services
 
 import (
 	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -30,7 +31,7 @@ func TestValidate(t *testing.T) {
 		err       error
 	}{
 		{
-			name: "test reorder config units",
+			name: "test reorder config units with priority",
 			units: []*Unit{
 				{
 					Name:    "hostname",
@@ -53,6 +54,26 @@ func TestValidate(t *testing.T) {
 				},
 			},
 			want: []*Service{
+				{
+					Unit: &Unit{
+						Seq:       3,
+						Name:      "test-py-http-server-4081",
+						Command:   "python3",
+						Kind:      "app",
+						Arguments: []string{"-m", "http.server", "4081"},
+						Priority:  100,
+					},
+					Status: &Status{
+						Current:     PendingStatus,
+						ServiceName: "test-py-http-server-4081",
+					},
+					State: &State{
+						Current:     PendingState,
+						ServiceName: "test-py-http-server-4081",
+					},
+					Kind: WorkerKind(ApplicationWorker),
+					Seq:  1,
+				},
 				{
 					Unit: &Unit{
 						Seq:     1,
@@ -69,7 +90,7 @@ func TestValidate(t *testing.T) {
 						ServiceName: "hostname",
 					},
 					Kind: WorkerKind(CommandWorker),
-					Seq:  1,
+					Seq:  2,
 				},
 				{
 					Unit: &Unit{
@@ -89,29 +110,269 @@ func TestValidate(t *testing.T) {
 						ServiceName: "test-py-http-server",
 					},
 					Kind: WorkerKind(ApplicationWorker),
+					Seq:  3,
+				},
+			},
+		},
+		{
+			name: "test reorder config units with before and after directives",
+			units: []*Unit{
+				{
+					Name:    "webapp",
+					Command: "webapp",
+					Kind:    "app",
+					After:   []string{"migrator"},
+				},
+				{
+					Name:    "migrator",
+					Command: "migrate",
+					Kind:    "command",
+				},
+				{
+					Name:    "database",
+					Command: "postgres",
+					Kind:    "app",
+					Before:  []string{"migrator"},
+				},
+			},
+			want: []*Service{
+				{
+					Unit: &Unit{
+						Seq:     3,
+						Name:    "database",
+						Command: "postgres",
+						Kind:    "app",
+						Before:  []string{"migrator"},
+					},
+					Status: &Status{
+						Current:     PendingStatus,
+						ServiceName: "database",
+					},
+					State: &State{
+						Current:     PendingState,
+						ServiceName: "database",
+					},
+					Kind: WorkerKind(ApplicationWorker),
+					Seq:  1,
+				},
+				{
+					Unit: &Unit{
+						Seq:     2,
+						Name:    "migrator",
+						Command: "migrate",
+						Kind:    "command",
+					},
+					Status: &Status{
+						Current:     PendingStatus,
+						ServiceName: "migrator",
+					},
+					State: &State{
+						Current:     PendingState,
+						ServiceName: "migrator",
+					},
+					Kind: WorkerKind(CommandWorker),
 					Seq:  2,
 				},
 				{
 					Unit: &Unit{
-						Seq:       3,
-						Name:      "test-py-http-server-4081",
-						Command:   "python3",
-						Kind:      "app",
-						Arguments: []string{"-m", "http.server", "4081"},
-						Priority:  100,
+						Seq:     1,
+						Name:    "webapp",
+						Command: "webapp",
+						Kind:    "app",
+						After:   []string{"migrator"},
 					},
 					Status: &Status{
 						Current:     PendingStatus,
-						ServiceName: "test-py-http-server-4081",
+						ServiceName: "webapp",
 					},
 					State: &State{
 						Current:     PendingState,
-						ServiceName: "test-py-http-server-4081",
+						ServiceName: "webapp",
+					},
+					Kind: WorkerKind(ApplicationWorker),
+					Seq:  3,
+				},
+			},
+		},
+		{
+			name: "test reorder config units with priority and dependencies",
+			units: []*Unit{
+				{
+					Name:    "zeta",
+					Command: "zeta",
+					Kind:    "app",
+				},
+				{
+					Name:     "beta",
+					Command:  "beta",
+					Kind:     "app",
+					Priority: 50,
+					After:    []string{"zeta"},
+				},
+				{
+					Name:    "alpha",
+					Command: "alpha",
+					Kind:    "app",
+				},
+				{
+					Name:     "gamma",
+					Command:  "gamma",
+					Kind:     "app",
+					Priority: 10,
+				},
+			},
+			want: []*Service{
+				{
+					Unit: &Unit{
+						Seq:      4,
+						Name:     "gamma",
+						Command:  "gamma",
+						Kind:     "app",
+						Priority: 10,
+					},
+					Status: &Status{
+						Current:     PendingStatus,
+						ServiceName: "gamma",
+					},
+					State: &State{
+						Current:     PendingState,
+						ServiceName: "gamma",
+					},
+					Kind: WorkerKind(ApplicationWorker),
+					Seq:  1,
+				},
+				{
+					Unit: &Unit{
+						Seq:     3,
+						Name:    "alpha",
+						Command: "alpha",
+						Kind:    "app",
+					},
+					Status: &Status{
+						Current:     PendingStatus,
+						ServiceName: "alpha",
+					},
+					State: &State{
+						Current:     PendingState,
+						ServiceName: "alpha",
+					},
+					Kind: WorkerKind(ApplicationWorker),
+					Seq:  2,
+				},
+				{
+					Unit: &Unit{
+						Seq:     1,
+						Name:    "zeta",
+						Command: "zeta",
+						Kind:    "app",
+					},
+					Status: &Status{
+						Current:     PendingStatus,
+						ServiceName: "zeta",
+					},
+					State: &State{
+						Current:     PendingState,
+						ServiceName: "zeta",
 					},
 					Kind: WorkerKind(ApplicationWorker),
 					Seq:  3,
 				},
+				{
+					Unit: &Unit{
+						Seq:      2,
+						Name:     "beta",
+						Command:  "beta",
+						Kind:     "app",
+						Priority: 50,
+						After:    []string{"zeta"},
+					},
+					Status: &Status{
+						Current:     PendingStatus,
+						ServiceName: "beta",
+					},
+					State: &State{
+						Current:     PendingState,
+						ServiceName: "beta",
+					},
+					Kind: WorkerKind(ApplicationWorker),
+					Seq:  4,
+				},
+			},
+		},
+		{
+			name: "test config units with dependency cycle",
+			units: []*Unit{
+				{
+					Name:    "hostname",
+					Command: "hostname",
+					Kind:    "command",
+				},
+				{
+					Name:    "foo",
+					Command: "foo",
+					Kind:    "app",
+					After:   []string{"hostname", "baz"},
+				},
+				{
+					Name:    "bar",
+					Command: "bar",
+					Kind:    "app",
+					After:   []string{"foo"},
+				},
+				{
+					Name:    "baz",
+					Command: "baz",
+					Kind:    "app",
+					After:   []string{"bar"},
+				},
+			},
+			shouldErr: true,
+			err:       fmt.Errorf("dependency cycle detected: foo -> bar -> baz -> foo"),
+		},
+		{
+			name: "test config units with self dependency",
+			units: []*Unit{
+				{
+					Name:    "foo",
+					Command: "foo",
+					Kind:    "app",
+					Before:  []string{"foo"},
+				},
+			},
+			shouldErr: true,
+			err:       fmt.Errorf("dependency cycle detected: foo -> foo"),
+		},
+		{
+			name: "test config units with unknown dependency",
+			units: []*Unit{
+				{
+					Name:    "foo",
+					Command: "foo",
+					Kind:    "app",
+					After:   []string{"bar"},
+				},
+			},
+			shouldErr: true,
+			err:       fmt.Errorf("the %q in %q directive for unit %q is not found", "bar", "after", "foo"),
+		},
+		{
+			name: "test config units requiring noop unit",
+			units: []*Unit{
+				{
+					Name:    "bar",
+					Command: "bar",
+					Kind:    "app",
+					Noop:    true,
+				},
+				{
+					Name:     "foo",
+					Command:  "foo",
+					Kind:     "app",
+					Requires: []string{"bar"},
+				},
 			},
+			shouldErr: true,
+			err:       fmt.Errorf("the %q in %q directive for unit %q is a noop unit", "bar", "requires", "foo"),
 		},
 	}
 	for _, tc := range testcases {
@@ -0,0 +1,122 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// Credential is the user and the groups the commands of a unit run as.
+type Credential struct {
+	Username string
+	HomeDir  string
+	Uid      uint32
+	Gid      uint32
+	Groups   []uint32
+	// If set to true, the supplementary groups remain intact.
+	NoSetGroups bool
+}
+
+// resolveCredential resolves the user, the group, and the supplementary
+// groups of the unit. It returns an error when any of them does not exist,
+// or when the current process lacks the privileges to switch to them.
+func (u *Unit) resolveCredential() error {
+	u.Credential = nil
+	if u.User == "" && u.Group == "" && len(u.SupplementaryGroups) == 0 {
+		return nil
+	}
+	if !credentialSupported {
+		return fmt.Errorf("unit %q: user and group are not supported on this platform", u.Name)
+	}
+
+	c := &Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	if u.User != "" {
+		usr, err := lookupUser(u.User)
+		if err != nil {
+			return fmt.Errorf("unit %q: user %q not found", u.Name, u.User)
+		}
+		uid, _ := strconv.ParseUint(usr.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(usr.Gid, 10, 32)
+		c.Username, c.HomeDir = usr.Username, usr.HomeDir
+		c.Uid, c.Gid = uint32(uid), uint32(gid)
+		if len(u.SupplementaryGroups) == 0 {
+			// The user keeps its group memberships.
+			gids, _ := usr.GroupIds()
+			for _, s := range gids {
+				if gid, err := strconv.ParseUint(s, 10, 32); err == nil && uint32(gid) != c.Gid {
+					c.Groups = append(c.Groups, uint32(gid))
+				}
+			}
+		}
+	}
+	if u.Group != "" {
+		gid, err := lookupGroup(u.Group)
+		if err != nil {
+			return fmt.Errorf("unit %q: group %q not found", u.Name, u.Group)
+		}
+		c.Gid = gid
+	}
+	for _, name := range u.SupplementaryGroups {
+		gid, err := lookupGroup(name)
+		if err != nil {
+			return fmt.Errorf("unit %q: supplementary group %q not found", u.Name, name)
+		}
+		c.Groups = append(c.Groups, gid)
+	}
+
+	if !canSetCredential() {
+		if c.Uid != uint32(os.Getuid()) || c.Gid != uint32(os.Getgid()) || len(u.SupplementaryGroups) > 0 {
+			return fmt.Errorf("unit %q: insufficient privileges to switch user and group", u.Name)
+		}
+		// The unit runs as the current user, without changing groups.
+		c.Groups = nil
+		c.NoSetGroups = true
+	}
+	u.Credential = c
+	return nil
+}
+
+// lookupUser looks up the user by name or ID.
+func lookupUser(s string) (*user.User, error) {
+	if _, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return user.LookupId(s)
+	}
+	return user.Lookup(s)
+}
+
+// lookupGroup looks up the ID of the group by name or ID.
+func lookupGroup(s string) (uint32, error) {
+	var g *user.Group
+	var err error
+	if _, err = strconv.ParseUint(s, 10, 32); err == nil {
+		g, err = user.LookupGroupId(s)
+	} else {
+		g, err = user.LookupGroup(s)
+	}
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.ParseUint(g.Gid, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(gid), nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+)
+
+func TestResolveCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root privileges")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("requires nobody user")
+	}
+
+	testcases := []struct {
+		name      string
+		unit      *Unit
+		want      *Credential
+		shouldErr bool
+		err       string
+	}{
+		{
+			name: "test resolve user",
+			unit: &Unit{Name: "webapp", User: "nobody", SupplementaryGroups: []string{"0"}},
+			want: &Credential{Username: "nobody", HomeDir: "/nonexistent", Uid: 65534, Gid: 65534, Groups: []uint32{0}},
+		},
+		{
+			name: "test resolve user and group by id",
+			unit: &Unit{Name: "webapp", User: "65534", Group: "0", SupplementaryGroups: []string{"0"}},
+			want: &Credential{Username: "nobody", HomeDir: "/nonexistent", Uid: 65534, Gid: 0, Groups: []uint32{0}},
+		},
+		{
+			name:      "test resolve missing user",
+			unit:      &Unit{Name: "webapp", User: "appd-missing-user"},
+			shouldErr: true,
+			err:       `unit "webapp": user "appd-missing-user" not found`,
+		},
+		{
+			name:      "test resolve missing group",
+			unit:      &Unit{Name: "webapp", User: "nobody", Group: "appd-missing-group"},
+			shouldErr: true,
+			err:       `unit "webapp": group "appd-missing-group" not found`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.unit.resolveCredential()
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if err.Error() != tc.err {
+					t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if diff := cmp.Diff(tc.want, tc.unit.Credential); diff != "" {
+				t.Errorf("resolveCredential() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestServiceCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root privileges")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("requires nobody user")
+	}
+
+	outFilePath := filepath.Join(t.TempDir(), "out.txt")
+	unit := &Unit{
+		Name:                "hostname",
+		Kind:                "command",
+		Command:             "sh",
+		Arguments:           []string{"-c", `echo $(id -u) $(id -g) $(id -G) $USER`},
+		WorkDirectory:       "/",
+		User:                "nobody",
+		SupplementaryGroups: []string{"0"},
+		StdOutFilePath:      outFilePath,
+	}
+	if err := unit.resolveCredential(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	b, err := os.ReadFile(outFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(b)), "65534 65534 65534 0 nobody"; got != want {
+		t.Errorf("unexpected output: %q, want: %q", got, want)
+	}
+}
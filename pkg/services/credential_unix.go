@@ -0,0 +1,45 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package services
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const credentialSupported = true
+
+// setCredential makes the command run as the user and the groups of the
+// credential.
+func setCredential(cmd *exec.Cmd, c *Credential) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:         c.Uid,
+		Gid:         c.Gid,
+		Groups:      c.Groups,
+		NoSetGroups: c.NoSetGroups,
+	}
+}
+
+// canSetCredential returns true when the current process is privileged to
+// switch user and groups.
+func canSetCredential() bool {
+	return os.Geteuid() == 0 || hasSetIDCapabilities()
+}
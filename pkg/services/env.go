@@ -0,0 +1,212 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// environ returns the environment of the unit's commands. Unless ClearEnv
+// is set, it starts with the environment of the current process, filtered
+// per the passthrough allowlist, with USER, LOGNAME, and HOME of the unit's
+// user. The variables from the env files override it, and the variables
+// from Environment override them.
+func (u *Unit) environ() ([]string, error) {
+	env, _ := u.inheritedEnv()
+	if c := u.Credential; c != nil && c.Username != "" {
+		env = setEnv(env, "USER", c.Username)
+		env = setEnv(env, "LOGNAME", c.Username)
+		env = setEnv(env, "HOME", c.HomeDir)
+	}
+	for _, fp := range u.EnvFiles {
+		vars, err := parseEnvFile(fp)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range vars {
+			env = setEnv(env, kv[0], kv[1])
+		}
+	}
+	keys := make([]string, 0, len(u.Environment))
+	for k := range u.Environment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = setEnv(env, k, u.Environment[k])
+	}
+	return env, nil
+}
+
+// inheritedEnv returns the environment variables of the current process
+// passed to the unit's commands, and the names of the dropped ones. When
+// neither the unit nor the config has the passthrough allowlist, all the
+// variables pass through.
+func (u *Unit) inheritedEnv() ([]string, []string) {
+	env := []string{}
+	var dropped []string
+	if u.ClearEnv {
+		return env, dropped
+	}
+	patterns := append(append([]string{}, u.GlobalEnvPassthrough...), u.EnvPassthrough...)
+	for _, kv := range os.Environ() {
+		if len(patterns) == 0 {
+			env = append(env, kv)
+			continue
+		}
+		k, _, _ := strings.Cut(kv, "=")
+		if matchEnvPattern(patterns, k) {
+			env = append(env, kv)
+			continue
+		}
+		dropped = append(dropped, k)
+	}
+	sort.Strings(dropped)
+	return env, dropped
+}
+
+// matchEnvPattern returns true when the name of the environment variable
+// matches any of the glob patterns, e.g. LC_*.
+func matchEnvPattern(patterns []string, k string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, k); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func validateEnvPattern(pattern string) error {
+	if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+		return fmt.Errorf("invalid environment variable pattern: %q", pattern)
+	}
+	return nil
+}
+
+// setEnv sets the variable in the environment, replacing the existing
+// value, if any.
+func setEnv(env []string, k, v string) []string {
+	for i, kv := range env {
+		if strings.HasPrefix(kv, k+"=") {
+			env[i] = k + "=" + v
+			return env
+		}
+	}
+	return append(env, k+"="+v)
+}
+
+// unsetEnv removes the variable from the environment.
+func unsetEnv(env []string, k string) []string {
+	out := env[:0]
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, k+"=") {
+			out = append(out, kv)
+		}
+	}
+	return out
+}
+
+func validateEnvName(k string) error {
+	if k == "" || strings.ContainsAny(k, "= \t\n\x00") {
+		return fmt.Errorf("invalid environment variable name: %q", k)
+	}
+	return nil
+}
+
+// parseEnvFile parses the file in dotenv format. Each line of the file is
+// a KEY=VALUE assignment, optionally prefixed with "export". The value may
+// be single-quoted, taken literally, or double-quoted, with \n, \t, \",
+// and \\ escape sequences. The lines starting with # are comments.
+func parseEnvFile(fp string) ([][2]string, error) {
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening env file: %w", err)
+	}
+	defer f.Close()
+
+	var vars [][2]string
+	scanner := bufio.NewScanner(f)
+	var lineNumber int
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, found := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !found || validateEnvName(k) != nil {
+			return nil, fmt.Errorf("malformed env file %s at line %d", fp, lineNumber)
+		}
+		v, err := parseEnvValue(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("malformed env file %s at line %d: %w", fp, lineNumber, err)
+		}
+		vars = append(vars, [2]string{k, v})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading env file: %w", err)
+	}
+	return vars, nil
+}
+
+func parseEnvValue(v string) (string, error) {
+	if v == "" {
+		return v, nil
+	}
+	switch v[0] {
+	case '\'':
+		i := strings.IndexByte(v[1:], '\'')
+		if i < 0 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		return v[1 : i+1], nil
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(v); i++ {
+			switch c := v[i]; c {
+			case '"':
+				return sb.String(), nil
+			case '\\':
+				i++
+				if i == len(v) {
+					return "", fmt.Errorf("unterminated quoted value")
+				}
+				switch v[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(v[i])
+				}
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated quoted value")
+	}
+	// The unquoted value may have a trailing comment.
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
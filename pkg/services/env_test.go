@@ -0,0 +1,221 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	testcases := []struct {
+		name      string
+		content   string
+		want      [][2]string
+		shouldErr bool
+		err       string
+	}{
+		{
+			name: "test parse env file",
+			content: `
+# database settings
+DB_HOST=localhost
+export DB_PORT=5432
+DB_NAME = webapp # inline comment
+DB_PASSWORD='p@ss "word" #1'
+GREETING="Hello,\tWorld!\nHi \"there\""
+EMPTY=
+`,
+			want: [][2]string{
+				{"DB_HOST", "localhost"},
+				{"DB_PORT", "5432"},
+				{"DB_NAME", "webapp"},
+				{"DB_PASSWORD", `p@ss "word" #1`},
+				{"GREETING", "Hello,\tWorld!\nHi \"there\""},
+				{"EMPTY", ""},
+			},
+		},
+		{
+			name:      "test parse env file with malformed line",
+			content:   "DB_HOST=localhost\nDB_PORT\n",
+			shouldErr: true,
+			err:       "at line 2",
+		},
+		{
+			name:      "test parse env file with unterminated value",
+			content:   "DB_PASSWORD=\"secret\n",
+			shouldErr: true,
+			err:       "at line 1: unterminated quoted value",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fp := filepath.Join(t.TempDir(), ".env")
+			if err := os.WriteFile(fp, []byte(tc.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseEnvFile(fp)
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("parseEnvFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnitEnviron(t *testing.T) {
+	t.Setenv("APPD_TEST_INHERITED", "caddy")
+	t.Setenv("APPD_TEST_OVERRIDDEN", "caddy")
+	fp := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(fp, []byte("APPD_TEST_OVERRIDDEN=file\nAPPD_TEST_FILE=file\nAPPD_TEST_DIRECTIVE=file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		name string
+		unit *Unit
+		want map[string]string
+	}{
+		{
+			name: "test inherited environment",
+			unit: &Unit{
+				EnvFiles:    []string{fp},
+				Environment: map[string]string{"APPD_TEST_DIRECTIVE": "directive"},
+			},
+			want: map[string]string{
+				"APPD_TEST_INHERITED":  "caddy",
+				"APPD_TEST_OVERRIDDEN": "file",
+				"APPD_TEST_FILE":       "file",
+				"APPD_TEST_DIRECTIVE":  "directive",
+			},
+		},
+		{
+			name: "test cleared environment",
+			unit: &Unit{
+				ClearEnv:    true,
+				EnvFiles:    []string{fp},
+				Environment: map[string]string{"APPD_TEST_DIRECTIVE": "directive"},
+			},
+			want: map[string]string{
+				"APPD_TEST_OVERRIDDEN": "file",
+				"APPD_TEST_FILE":       "file",
+				"APPD_TEST_DIRECTIVE":  "directive",
+			},
+		},
+		{
+			name: "test environment passthrough",
+			unit: &Unit{
+				EnvPassthrough:       []string{"APPD_TEST_INH*"},
+				GlobalEnvPassthrough: []string{"PATH"},
+				Environment:          map[string]string{"APPD_TEST_DIRECTIVE": "directive"},
+			},
+			want: map[string]string{
+				"APPD_TEST_INHERITED": "caddy",
+				"APPD_TEST_DIRECTIVE": "directive",
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := tc.unit.environ()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, kv := range env {
+				k, v, _ := strings.Cut(kv, "=")
+				if strings.HasPrefix(k, "APPD_TEST_") || tc.unit.ClearEnv {
+					got[k] = v
+				}
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("environ() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnitInheritedEnv(t *testing.T) {
+	t.Setenv("APPD_TEST_SECRET", "secret")
+	t.Setenv("APPD_TEST_PUBLIC", "public")
+	unit := &Unit{
+		EnvPassthrough:       []string{"APPD_TEST_PUB*"},
+		GlobalEnvPassthrough: []string{"PATH", "HOME"},
+	}
+	env, dropped := unit.inheritedEnv()
+	for _, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		if k != "PATH" && k != "HOME" && k != "APPD_TEST_PUBLIC" {
+			t.Errorf("unexpected inherited variable: %s", k)
+		}
+	}
+	var found bool
+	for _, k := range dropped {
+		if k == "APPD_TEST_SECRET" {
+			found = true
+		}
+		if k == "APPD_TEST_PUBLIC" || k == "PATH" {
+			t.Errorf("unexpected dropped variable: %s", k)
+		}
+	}
+	if !found {
+		t.Errorf("expected dropped APPD_TEST_SECRET, got: %v", dropped)
+	}
+}
+
+func TestServiceWorkDirectoryAndEnv(t *testing.T) {
+	dir := t.TempDir()
+	outFilePath := filepath.Join(dir, "out.txt")
+	unit := &Unit{
+		Name:           "hostname",
+		Kind:           "command",
+		Command:        "sh",
+		Arguments:      []string{"-c", `echo "$(pwd) $GREETING" > out.txt`},
+		WorkDirectory:  dir,
+		Environment:    map[string]string{"GREETING": "hello"},
+		StdOutFilePath: filepath.Join(dir, "stdout.log"),
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	b, err := os.ReadFile(outFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDir, _ := filepath.EvalSymlinks(dir)
+	if got, want := strings.TrimSpace(string(b)), wantDir+" hello"; got != want {
+		t.Errorf("unexpected output: %q, want: %q", got, want)
+	}
+}
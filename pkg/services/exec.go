@@ -0,0 +1,39 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+// defaultReloadTimeout is the time the reload command has to complete.
+const defaultReloadTimeout = 90 * time.Second
+
+// Exec is a command run in the environment of a unit, e.g. to stop or to
+// reload an app.
+type Exec struct {
+	// The command to execute.
+	Command string `json:"cmd,omitempty"`
+	// The executed command arguments.
+	Arguments []string `json:"args,omitempty"`
+}
+
+func (e *Exec) validate() error {
+	if e.Command == "" {
+		return fmt.Errorf("empty command")
+	}
+	return nil
+}
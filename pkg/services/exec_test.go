@@ -0,0 +1,142 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceExecStop(t *testing.T) {
+	testcases := []struct {
+		name        string
+		execStop    *Exec
+		minDuration time.Duration
+		maxDuration time.Duration
+	}{
+		{
+			name:        "test stop command stops app",
+			execStop:    &Exec{Command: "sh", Arguments: []string{"-c", "kill -USR1 $MAINPID"}},
+			maxDuration: 500 * time.Millisecond,
+		},
+		{
+			name:        "test failing stop command falls back to stop signal",
+			execStop:    &Exec{Command: "false"},
+			maxDuration: 500 * time.Millisecond,
+		},
+		{
+			name:        "test app outliving stop command gets stopped with stop signal",
+			execStop:    &Exec{Command: "true"},
+			minDuration: time.Second,
+			maxDuration: 2 * time.Second,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			unit := &Unit{
+				Name:        "webapp",
+				Kind:        "app",
+				Command:     "sh",
+				Arguments:   []string{"-c", `trap "exit 0" USR1 TERM; while true; do sleep 0.05; done`},
+				StopSignal:  "SIGTERM",
+				StopTimeout: Duration(time.Second),
+				ExecStop:    tc.execStop,
+			}
+			if err := unit.validate(); err != nil {
+				t.Fatal(err)
+			}
+			svc, err := NewService(0, unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.Start(); err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			time.Sleep(100 * time.Millisecond)
+
+			stoppedAt := time.Now()
+			if err := svc.Stop(); err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			if elapsed := time.Since(stoppedAt); elapsed < tc.minDuration || elapsed > tc.maxDuration {
+				t.Errorf("stop took %v, want between %v and %v", elapsed, tc.minDuration, tc.maxDuration)
+			}
+		})
+	}
+}
+
+func TestServiceReload(t *testing.T) {
+	dir := t.TempDir()
+	reloadedFilePath := filepath.Join(dir, "reloaded")
+
+	testcases := []struct {
+		name       string
+		execReload *Exec
+		want       string
+	}{
+		{
+			name: "test reload with signal",
+			want: "SIGHUP",
+		},
+		{
+			name:       "test reload with command",
+			execReload: &Exec{Command: "sh", Arguments: []string{"-c", "kill -USR1 $MAINPID"}},
+			want:       "SIGUSR1",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Remove(reloadedFilePath)
+			unit := &Unit{
+				Name:    "webapp",
+				Kind:    "app",
+				Command: "sh",
+				Arguments: []string{"-c", `
+trap "echo SIGHUP > $0" HUP
+trap "echo SIGUSR1 > $0" USR1
+while true; do sleep 0.05; done`, reloadedFilePath},
+				ExecReload: tc.execReload,
+			}
+			svc, err := NewService(0, unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.Start(); err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			defer svc.Stop()
+			time.Sleep(100 * time.Millisecond)
+
+			if err := svc.Reload(); err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			var got string
+			deadline := time.Now().Add(2 * time.Second)
+			for time.Now().Before(deadline) && got == "" {
+				time.Sleep(20 * time.Millisecond)
+				b, _ := os.ReadFile(reloadedFilePath)
+				got = strings.TrimSpace(string(b))
+			}
+			if got != tc.want {
+				t.Errorf("unexpected reload: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
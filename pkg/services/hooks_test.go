@@ -0,0 +1,139 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestServiceHooks(t *testing.T) {
+	logFilePath := filepath.Join(t.TempDir(), "hooks.log")
+	record := func(s string) *Exec {
+		return &Exec{Command: "sh", Arguments: []string{"-c", "echo " + s + " >> " + logFilePath}}
+	}
+	// The app is ready once it records its start.
+	readyProbe := &Probe{
+		Kind:      ExecProbe,
+		Target:    "grep",
+		Arguments: []string{"-q", "app", logFilePath},
+		Interval:  Duration(20 * time.Millisecond),
+	}
+
+	testcases := []struct {
+		name      string
+		unit      *Unit
+		stop      bool
+		want      []string
+		shouldErr bool
+		err       string
+	}{
+		{
+			name: "test command with hooks",
+			unit: &Unit{
+				Name:          "migrate",
+				Kind:          "command",
+				Command:       "sh",
+				Arguments:     []string{"-c", "echo cmd >> " + logFilePath},
+				ExecStartPre:  []*Exec{record("pre1"), record("pre2")},
+				ExecStartPost: []*Exec{record("post")},
+			},
+			want: []string{"pre1", "pre2", "cmd", "post"},
+		},
+		{
+			name: "test failed pre hook aborts start",
+			unit: &Unit{
+				Name:         "webapp",
+				Kind:         "app",
+				Command:      "sh",
+				Arguments:    []string{"-c", "echo app >> " + logFilePath + "; exec sleep 60"},
+				ExecStartPre: []*Exec{record("pre"), {Command: "false"}, record("skipped")},
+				ExecStopPost: []*Exec{record("stop_post")},
+			},
+			want:      []string{"pre"},
+			shouldErr: true,
+			err:       `exec_start_pre command "false" failed: exit status 1`,
+		},
+		{
+			name: "test app with hooks",
+			unit: &Unit{
+				Name:          "webapp",
+				Kind:          "app",
+				Command:       "sh",
+				Arguments:     []string{"-c", "echo app >> " + logFilePath + "; exec sleep 60"},
+				ExecStartPre:  []*Exec{record("pre")},
+				ExecStartPost: []*Exec{record("post")},
+				ExecStopPost:  []*Exec{record("stop_post")},
+				ReadyProbe:    readyProbe,
+			},
+			stop: true,
+			want: []string{"pre", "app", "post", "stop_post"},
+		},
+		{
+			name: "test failed post hook fails start",
+			unit: &Unit{
+				Name:          "webapp",
+				Kind:          "app",
+				Command:       "sh",
+				Arguments:     []string{"-c", "echo app >> " + logFilePath + "; exec sleep 60"},
+				ExecStartPost: []*Exec{{Command: "false"}},
+				ExecStopPost:  []*Exec{record("stop_post")},
+				ReadyProbe:    readyProbe,
+			},
+			want:      []string{"app", "stop_post"},
+			shouldErr: true,
+			err:       `exec_start_post command "false" failed: exit status 1`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Remove(logFilePath)
+			if err := tc.unit.validate(); err != nil {
+				t.Fatal(err)
+			}
+			svc, err := NewService(0, tc.unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = svc.Start()
+			if tc.shouldErr {
+				if err == nil || err.Error() != tc.err {
+					t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+				}
+				if status := svc.GetStatus(); status.Current != FailureStatus || status.Error == nil || status.Error.Error() != tc.err {
+					t.Fatalf("unexpected status: %v, %v", status.Current, status.Error)
+				}
+			} else if err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			if tc.stop {
+				if err := svc.Stop(); err != nil {
+					t.Fatalf("expected success, got: %v", err)
+				}
+			}
+
+			b, _ := os.ReadFile(logFilePath)
+			got := strings.Fields(string(b))
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("unexpected hooks: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Landlock is the unprivileged filesystem sandbox of the commands of a
+// unit. The commands may access only the files beneath the readable and
+// the writable paths.
+type Landlock struct {
+	// The paths the commands may read and execute.
+	Readable []string `json:"readable,omitempty"`
+	// The paths the commands may read, execute, and modify.
+	Writable []string `json:"writable,omitempty"`
+	// If set to true, the unit fails when the kernel does not support
+	// Landlock. Otherwise, the commands run without the restrictions.
+	Required bool `json:"required,omitempty"`
+}
+
+// launchLandlock is the Landlock ruleset the launcher enforces on the
+// command.
+type launchLandlock struct {
+	Readable []string `json:"readable,omitempty"`
+	Writable []string `json:"writable,omitempty"`
+}
+
+// validateLandlock checks the Landlock settings of the unit.
+func (u *Unit) validateLandlock() error {
+	if u.Landlock == nil {
+		return nil
+	}
+	if len(u.Landlock.Readable) == 0 && len(u.Landlock.Writable) == 0 {
+		return fmt.Errorf("landlock: no readable or writable paths")
+	}
+	for _, p := range u.landlockPaths() {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("landlock: invalid %q path", p)
+		}
+	}
+	if u.Landlock.Required && landlockABI() == 0 {
+		return fmt.Errorf("landlock is not supported by the kernel")
+	}
+	return nil
+}
+
+// landlockPaths returns the readable and the writable paths of the unit.
+func (u *Unit) landlockPaths() []string {
+	if u.Landlock == nil {
+		return nil
+	}
+	var paths []string
+	paths = append(paths, u.Landlock.Readable...)
+	return append(paths, u.Landlock.Writable...)
+}
+
+// landlockUnsupported returns true when the unit asks for the Landlock
+// sandbox, but the kernel does not support it.
+func (u *Unit) landlockUnsupported() bool {
+	return u.Landlock != nil && landlockABI() == 0
+}
+
+// landlock returns the Landlock ruleset of the unit, if the kernel
+// supports it.
+func (u *Unit) landlock() *launchLandlock {
+	if u.Landlock == nil || landlockABI() == 0 {
+		return nil
+	}
+	l := &launchLandlock{}
+	for _, p := range u.Landlock.Readable {
+		l.Readable = append(l.Readable, filepath.Clean(p))
+	}
+	for _, p := range u.Landlock.Writable {
+		l.Writable = append(l.Writable, filepath.Clean(p))
+	}
+	return l
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+
+package services
+
+import (
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The access rights of the readable paths.
+const landlockReadAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+// The access rights applicable to the files, as opposed to the directories.
+const landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_TRUNCATE
+
+var landlockVersion struct {
+	once sync.Once
+	abi  int
+}
+
+// landlockABI returns the version of the Landlock ABI of the kernel, or
+// zero when the kernel does not support Landlock.
+func landlockABI() int {
+	landlockVersion.once.Do(func() {
+		v, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+		if errno == 0 {
+			landlockVersion.abi = int(v)
+		}
+	})
+	return landlockVersion.abi
+}
+
+// landlockHandledAccess returns the access rights the ruleset restricts
+// with the ABI version.
+func landlockHandledAccess(abi int) uint64 {
+	access := uint64(unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1)
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	return access
+}
+
+// applyLandlock restricts the current thread, and the command it
+// executes, to the paths of the ruleset.
+func applyLandlock(l *launchLandlock) error {
+	handled := landlockHandledAccess(landlockABI())
+	attr := &unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(attr)), unsafe.Sizeof(*attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed creating landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+	for _, p := range l.Readable {
+		if err := addLandlockRule(int(fd), p, landlockReadAccess&handled); err != nil {
+			return err
+		}
+	}
+	for _, p := range l.Writable {
+		if err := addLandlockRule(int(fd), p, handled); err != nil {
+			return err
+		}
+	}
+	_, _, errno = unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0)
+	if errno == unix.EPERM {
+		if err := setNoNewPrivileges(); err != nil {
+			return err
+		}
+		_, _, errno = unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0)
+	}
+	if errno != 0 {
+		return fmt.Errorf("failed enforcing landlock ruleset: %w", errno)
+	}
+	return nil
+}
+
+// addLandlockRule allows the access to the files beneath the path.
+func addLandlockRule(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed opening landlock path %s: %w", path, err)
+	}
+	defer unix.Close(fd)
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("failed opening landlock path %s: %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+	rule := &unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(rule)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("failed adding landlock path %s: %w", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestValidateLandlock(t *testing.T) {
+	testcases := []struct {
+		name     string
+		landlock *Landlock
+		err      string
+	}{
+		{
+			name:     "test landlock without paths",
+			landlock: &Landlock{},
+			err:      `unit "webapp": landlock: no readable or writable paths`,
+		},
+		{
+			name:     "test landlock with relative path",
+			landlock: &Landlock{Readable: []string{"/usr"}, Writable: []string{"data"}},
+			err:      `unit "webapp": landlock: invalid "data" path`,
+		},
+	}
+	if landlockABI() == 0 {
+		testcases = append(testcases, struct {
+			name     string
+			landlock *Landlock
+			err      string
+		}{
+			name:     "test required landlock",
+			landlock: &Landlock{Readable: []string{"/usr"}, Required: true},
+			err:      `unit "webapp": landlock is not supported by the kernel`,
+		})
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			unit := &Unit{Name: "webapp", Kind: "app", Command: "webapp", Landlock: tc.landlock}
+			if err := unit.validate(); err == nil || err.Error() != tc.err {
+				t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestServiceLandlock(t *testing.T) {
+	if landlockABI() == 0 {
+		t.Skip("requires landlock")
+	}
+	var readable []string
+	for _, p := range []string{"/bin", "/usr", "/lib", "/lib64", "/etc"} {
+		if _, err := os.Stat(p); err == nil {
+			readable = append(readable, p)
+		}
+	}
+	dir, dataDir := t.TempDir(), t.TempDir()
+	readable = append(readable, dir)
+	unit := &Unit{
+		Name:    "webapp",
+		Kind:    "command",
+		Command: "sh",
+		Arguments: []string{"-c", `
+			for p in "$1" "$2"; do
+			  if (echo data > "$p/data.txt") 2>/dev/null; then echo written; else echo denied; fi
+			done
+			ls "$2"`, "sh", dir, dataDir},
+		WorkDirectory:  dir,
+		Landlock:       &Landlock{Readable: readable, Writable: []string{dataDir, "/dev/null"}},
+		StdOutFilePath: filepath.Join(t.TempDir(), "stdout.log"),
+	}
+	if err := unit.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateSandboxPaths(unit); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	b, err := os.ReadFile(unit.StdOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Fields(string(b)), []string{"denied", "written", "data.txt"}; strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("unexpected output: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import "os/exec"
+
+// launchSpecEnv is the environment variable holding the launch spec of the
+// command. When set, the process acts as the launcher of the command.
+const launchSpecEnv = "APPD_LAUNCH_SPEC"
+
+// launchSpec is the configuration the launcher applies to its own process
+// prior to replacing it with the command. It covers the settings that
+// cannot be applied by the parent process.
+type launchSpec struct {
+	// The path to the command.
+	Path string `json:"path"`
+	// If set to true, the launcher exports its PID in WATCHDOG_PID
+	// environment variable. The variable is optional, so it does not
+	// require the launcher.
+	WatchdogPid bool `json:"watchdog_pid,omitempty"`
+	// If set to true, the launcher exports its PID in LISTEN_PID
+	// environment variable.
+	ListenPid bool `json:"listen_pid,omitempty"`
+	// The resource limits of the command.
+	Rlimits []launchRlimit `json:"rlimits,omitempty"`
+	// The mounts of the filesystem sandbox of the command.
+	Mounts []launchMount `json:"mounts,omitempty"`
+	// The directories remaining accessible regardless of the mounts, e.g.
+	// the directory of the notify socket.
+	KeepPaths []string `json:"keep_paths,omitempty"`
+	// If set to true, the command starts in a new network namespace with
+	// the loopback interface only.
+	PrivateNetwork bool `json:"private_network,omitempty"`
+	// The PID of the app whose mount and network namespaces the command
+	// joins, instead of creating its own.
+	JoinPid int `json:"join_pid,omitempty"`
+	// The capabilities the command retains. When set, the others get
+	// dropped.
+	Capabilities []uint `json:"capabilities,omitempty"`
+	// If set to true, the command cannot gain privileges.
+	NoNewPrivileges bool `json:"no_new_privileges,omitempty"`
+	// The Landlock ruleset of the command.
+	Landlock *launchLandlock `json:"landlock,omitempty"`
+	// The syscall filter of the command.
+	SyscallFilter *launchSyscallFilter `json:"syscall_filter,omitempty"`
+	// The user and the groups the command runs as. The launcher switches
+	// to them after applying the other settings, which may require the
+	// privileges of the current process.
+	Credential *Credential `json:"credential,omitempty"`
+}
+
+// required returns true when the command needs the launcher.
+func (spec *launchSpec) required() bool {
+	return spec.ListenPid || spec.PrivateNetwork || spec.JoinPid > 0 || spec.NoNewPrivileges ||
+		spec.Landlock != nil || spec.SyscallFilter != nil || len(spec.Rlimits) > 0 || len(spec.Mounts) > 0 ||
+		len(spec.Capabilities) > 0
+}
+
+// prepare adds the settings of the unit to the spec and makes the command
+// apply them. The command starts via the launcher only when required.
+func (spec *launchSpec) prepare(cmd *exec.Cmd, unit *Unit) error {
+	spec.Rlimits = unit.rlimits()
+	if spec.JoinPid == 0 {
+		spec.Mounts = unit.mounts()
+		spec.PrivateNetwork = unit.PrivateNetwork
+	}
+	spec.Capabilities = unit.capabilityBits()
+	spec.NoNewPrivileges = unit.NoNewPrivileges
+	spec.Landlock = unit.landlock()
+	spec.SyscallFilter = unit.syscallFilter()
+	if !spec.required() {
+		if unit.Credential != nil {
+			setCredential(cmd, unit.Credential)
+		}
+		return nil
+	}
+	spec.Credential = unit.Credential
+	if len(spec.Mounts) > 0 {
+		setMountNamespace(cmd)
+	}
+	if spec.PrivateNetwork {
+		setNetworkNamespace(cmd)
+	}
+	return spec.wrap(cmd)
+}
@@ -0,0 +1,162 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+const launcherSupported = true
+
+// launcherExitCode is the exit code of the launcher when it fails to
+// execute the command.
+const launcherExitCode = 127
+
+func init() {
+	v, exists := os.LookupEnv(launchSpecEnv)
+	if !exists {
+		return
+	}
+	if err := launch(v); err != nil {
+		fmt.Fprintf(os.Stderr, "appd launcher: %v\n", err)
+		os.Exit(launcherExitCode)
+	}
+}
+
+// launch applies the launch spec to the current process and replaces it
+// with the command. It returns only on failure.
+func launch(s string) error {
+	spec := &launchSpec{}
+	if err := json.Unmarshal([]byte(s), spec); err != nil {
+		return fmt.Errorf("malformed launch spec: %w", err)
+	}
+	os.Unsetenv(launchSpecEnv)
+	// The capabilities and no_new_privs are the attributes of the thread
+	// executing the command.
+	runtime.LockOSThread()
+	if spec.WatchdogPid {
+		os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	}
+	if spec.ListenPid {
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	}
+	for _, limit := range spec.Rlimits {
+		if err := setRlimit(limit); err != nil {
+			return fmt.Errorf("failed setting resource limit %d: %w", limit.Resource, err)
+		}
+	}
+	if spec.JoinPid > 0 {
+		if err := joinNamespaces(spec.JoinPid); err != nil {
+			return err
+		}
+	}
+	if len(spec.Mounts) > 0 {
+		if err := applyMounts(spec.Mounts, spec.KeepPaths); err != nil {
+			return err
+		}
+	}
+	if spec.PrivateNetwork {
+		if err := setLoopbackUp(); err != nil {
+			return err
+		}
+	}
+	if len(spec.Capabilities) > 0 {
+		if err := dropCapabilities(spec.Capabilities); err != nil {
+			return err
+		}
+	}
+	if c := spec.Credential; c != nil {
+		if err := switchCredential(c); err != nil {
+			return err
+		}
+	}
+	if len(spec.Capabilities) > 0 {
+		if err := raiseCapabilities(spec.Capabilities); err != nil {
+			return err
+		}
+	}
+	if spec.NoNewPrivileges {
+		if err := setNoNewPrivileges(); err != nil {
+			return err
+		}
+	}
+	if spec.Landlock != nil {
+		if err := applyLandlock(spec.Landlock); err != nil {
+			return err
+		}
+	}
+	// The syscall filter applies to the launcher too, and comes last.
+	if spec.SyscallFilter != nil {
+		if err := installSyscallFilter(spec.SyscallFilter); err != nil {
+			return err
+		}
+	}
+	if err := syscall.Exec(spec.Path, os.Args, os.Environ()); err != nil {
+		return fmt.Errorf("failed executing %s: %w", spec.Path, err)
+	}
+	return nil
+}
+
+// switchCredential switches the current process to the user and the
+// groups of the credential.
+func switchCredential(c *Credential) error {
+	if !c.NoSetGroups {
+		groups := make([]int, 0, len(c.Groups))
+		for _, g := range c.Groups {
+			groups = append(groups, int(g))
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			return fmt.Errorf("failed setting supplementary groups: %w", err)
+		}
+	}
+	if err := syscall.Setgid(int(c.Gid)); err != nil {
+		return fmt.Errorf("failed setting group: %w", err)
+	}
+	if err := syscall.Setuid(int(c.Uid)); err != nil {
+		return fmt.Errorf("failed setting user: %w", err)
+	}
+	return nil
+}
+
+// wrap makes the command start via the launcher, i.e. the executable of
+// the current process, which applies the spec and executes the command.
+func (spec *launchSpec) wrap(cmd *exec.Cmd) error {
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed locating launcher: %w", err)
+	}
+	spec.Path = cmd.Path
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, launchSpecEnv+"="+string(b))
+	cmd.Path = exe
+	return nil
+}
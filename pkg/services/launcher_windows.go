@@ -0,0 +1,31 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package services
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// The launcher is not supported on Windows.
+const launcherSupported = false
+
+// wrap makes the command start via the launcher. The launcher is not
+// supported on Windows.
+func (spec *launchSpec) wrap(cmd *exec.Cmd) error {
+	return fmt.Errorf("process launcher is not supported on windows")
+}
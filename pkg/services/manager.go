@@ -15,34 +15,98 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
+	"os"
 	"sort"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
 
+// ErrServiceNotFound is the error of the operations on the services the
+// manager does not have.
+var ErrServiceNotFound = errors.New("service not found")
+
 // Manager manages services.
 type Manager struct {
 	mu          sync.Mutex
 	Services    []*Service `json:"services,omitempty"`
 	provisioned bool
-	started     bool
 	logger      *zap.Logger
+	// The groups of services that start concurrently, in the order of
+	// their start.
+	layers [][]*Service
+	// The names of the services other services want, but do not require.
+	// Their failures do not fail the start of the services.
+	wantedOnly        map[string]bool
+	maxParallelStarts int
+	startJitter       time.Duration
+	// The names of the services that exited unexpectedly since the start
+	// of the services.
+	exitMu sync.Mutex
+	exited map[string]bool
 }
 
 // NewManager parses config and creates Manager instance.
 func NewManager(cfg *Config, logger *zap.Logger) (*Manager, error) {
-	m := &Manager{}
+	m := &Manager{
+		maxParallelStarts: cfg.MaxParallelStarts,
+		startJitter:       time.Duration(cfg.StartJitter),
+		wantedOnly:        make(map[string]bool),
+		exited:            make(map[string]bool),
+	}
 	m.logger = logger
-	cfg.unitOrderAsc()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.order(); err != nil {
+		return nil, err
+	}
 	logger.Debug("initializing manager", zap.Any("configuration", cfg))
+	required := make(map[string]bool)
+	for _, unit := range cfg.Units {
+		for _, dep := range unit.Requires {
+			required[dep] = true
+		}
+	}
+	for _, unit := range cfg.Units {
+		for _, dep := range unit.Wants {
+			if !required[dep] {
+				m.wantedOnly[dep] = true
+			}
+		}
+	}
 	for i, unit := range cfg.Units {
 		svc, err := NewService(i, unit, logger)
 		if err != nil {
 			return nil, err
 		}
+		svc.onExit = m.handleExit
+		if unit.landlockUnsupported() {
+			logger.Warn("landlock is not supported by the kernel, running service without it",
+				zap.String("service_name", unit.Name),
+			)
+		}
+		if _, dropped := unit.inheritedEnv(); len(dropped) > 0 {
+			logger.Debug("dropped environment variables",
+				zap.String("service_name", unit.Name),
+				zap.Strings("names", dropped),
+			)
+		}
 		m.Services = append(m.Services, svc)
+		depth := cfg.depths[unit.Name]
+		for len(m.layers) <= depth {
+			m.layers = append(m.layers, nil)
+		}
+		m.layers[depth] = append(m.layers[depth], svc)
+	}
+	for _, layer := range m.layers {
+		sort.SliceStable(layer, func(a, b int) bool {
+			return unitLess(layer[a].Unit, layer[b].Unit)
+		})
 	}
 	logger.Debug("configured services", zap.Any("services", m.Services))
 	m.provisioned = true
@@ -63,48 +127,173 @@ func (m *Manager) Start() []*Status {
 			}}
 	}
 
-	for _, svc := range m.Services {
-		if svc.Unit.Noop {
-			m.logger.Debug("skipped starting service",
-				zap.String("service_name", svc.Unit.Name),
-				zap.String("kind", svc.Unit.Kind),
-				zap.String("reason", "noop"),
-				zap.Int("seq_id", svc.Seq),
-			)
-			continue
-		}
+	svcErrors := []*Status{}
+	// The names of the services that are not running or completed.
+	inactive := make(map[string]bool)
 
-		if svc.Unit.StdOutFilePath != "" {
-			if err := validateFilePath(svc.Unit.StdOutFilePath); err != nil {
-				return []*Status{
-					{
-						Current:     FailureStatus,
-						ServiceName: svc.Unit.Name,
-						Error:       err,
-					}}
+	var sem chan struct{}
+	if m.maxParallelStarts > 0 {
+		sem = make(chan struct{}, m.maxParallelStarts)
+	}
+
+	m.exitMu.Lock()
+	clear(m.exited)
+	m.exitMu.Unlock()
+
+	for _, layer := range m.layers {
+		// The services that exited after starting in the previous layers
+		// are no longer active.
+		m.exitMu.Lock()
+		for name := range m.exited {
+			inactive[name] = true
+		}
+		m.exitMu.Unlock()
+		var wg sync.WaitGroup
+		failed := make([]bool, len(layer))
+		for i, svc := range layer {
+			if svc.Unit.Noop {
+				m.logger.Debug("skipped starting service",
+					zap.String("service_name", svc.Unit.Name),
+					zap.String("kind", svc.Unit.Kind),
+					zap.String("reason", "noop"),
+					zap.Int("seq_id", svc.Seq),
+				)
+				inactive[svc.Unit.Name] = true
+				continue
 			}
+			if err := m.precheck(svc, inactive); err != nil {
+				svcErrors = m.addFailure(svcErrors, svc)
+				inactive[svc.Unit.Name] = true
+				continue
+			}
+			// Without the jitter, the services take the slots of the
+			// concurrent starts in the order of their priority. The jitter
+			// delays the start without holding a slot, so the order of the
+			// delayed starts is random.
+			if sem != nil && m.startJitter == 0 {
+				sem <- struct{}{}
+			}
+			wg.Add(1)
+			go func(i int, svc *Service) {
+				defer wg.Done()
+				if m.startJitter > 0 {
+					time.Sleep(time.Duration(rand.Int63n(int64(m.startJitter))))
+					if sem != nil {
+						sem <- struct{}{}
+					}
+				}
+				if sem != nil {
+					defer func() { <-sem }()
+				}
+				if err := svc.Start(); err != nil {
+					failed[i] = true
+				}
+			}(i, svc)
 		}
-
-		if svc.Unit.StdErrFilePath != "" {
-			if err := validateFilePath(svc.Unit.StdErrFilePath); err != nil {
-				return []*Status{
-					{
-						Current:     FailureStatus,
-						ServiceName: svc.Unit.Name,
-						Error:       err,
-					}}
+		wg.Wait()
+		for i, svc := range layer {
+			if failed[i] {
+				svcErrors = m.addFailure(svcErrors, svc)
+				inactive[svc.Unit.Name] = true
 			}
 		}
+	}
+
+	if len(svcErrors) > 0 {
+		// Caddy does not stop the app failing to start, so the services
+		// that started must not outlive the failure.
+		for _, st := range m.stop() {
+			m.logger.Error("failed stopping service",
+				zap.String("service_name", st.ServiceName),
+				zap.Error(st.Error),
+			)
+		}
+		sort.Slice(svcErrors, func(a, b int) bool {
+			return m.seq(svcErrors[a].ServiceName) < m.seq(svcErrors[b].ServiceName)
+		})
+		return svcErrors
+	}
+	return nil
+}
+
+// addFailure adds the status of the service that failed to start to the
+// errors. The failures of the services other services only want are
+// logged instead.
+func (m *Manager) addFailure(svcErrors []*Status, svc *Service) []*Status {
+	st := svc.GetStatus()
+	if m.wantedOnly[svc.Unit.Name] {
+		m.logger.Warn("wanted service failed to start",
+			zap.String("service_name", svc.Unit.Name),
+			zap.String("kind", svc.Unit.Kind),
+			zap.Int("seq_id", svc.Seq),
+			zap.Error(st.Error),
+		)
+		return svcErrors
+	}
+	return append(svcErrors, st)
+}
 
-		if err := svc.Start(); err != nil {
-			return []*Status{svc.Status}
+// precheck checks whether the service is ready to start. It returns an
+// error when the service must not start because of its dependencies or
+// configuration.
+func (m *Manager) precheck(svc *Service, inactive map[string]bool) error {
+	if err := checkRequirements(svc, inactive); err != nil {
+		m.logger.Debug("skipped starting service",
+			zap.String("service_name", svc.Unit.Name),
+			zap.String("kind", svc.Unit.Kind),
+			zap.String("reason", "dependency"),
+			zap.Int("seq_id", svc.Seq),
+			zap.Error(err),
+		)
+		svc.State.Current = CompletedState
+		svc.Status.Current = DependencyFailedStatus
+		svc.Status.Error = err
+		return err
+	}
+
+	for _, dep := range svc.Unit.Wants {
+		if inactive[dep] {
+			m.logger.Debug("starting service without wanted unit",
+				zap.String("service_name", svc.Unit.Name),
+				zap.String("kind", svc.Unit.Kind),
+				zap.String("wanted_service_name", dep),
+				zap.Int("seq_id", svc.Seq),
+			)
 		}
 	}
 
-	m.started = true
+	if err := validateOutputFiles(svc.Unit); err != nil {
+		svc.State.Current = CompletedState
+		svc.Status.Current = FailureStatus
+		svc.Status.Error = err
+		return err
+	}
+
+	if err := validateWorkDirectory(svc.Unit); err != nil {
+		svc.State.Current = CompletedState
+		svc.Status.Current = FailureStatus
+		svc.Status.Error = err
+		return err
+	}
+	if err := validateSandboxPaths(svc.Unit); err != nil {
+		svc.State.Current = CompletedState
+		svc.Status.Current = FailureStatus
+		svc.Status.Error = err
+		return err
+	}
 	return nil
 }
 
+// seq returns the start sequence of the service with the provided name.
+func (m *Manager) seq(name string) int {
+	for _, svc := range m.Services {
+		if svc.Unit.Name == name {
+			return svc.Seq
+		}
+	}
+	return 0
+}
+
 // Stop stops services.
 func (m *Manager) Stop() []*Status {
 	m.mu.Lock()
@@ -118,16 +307,15 @@ func (m *Manager) Stop() []*Status {
 				Error:       fmt.Errorf("provisioning has failed"),
 			}}
 	}
+	return m.stop()
+}
 
-	desc := func(a, b int) bool {
-		return m.Services[a].Seq > m.Services[b].Seq
-	}
-
-	sort.Slice(m.Services, desc)
-
+// stop stops services in the reverse order of their start. The caller
+// must hold m.mu.
+func (m *Manager) stop() []*Status {
 	svcErrors := []*Status{}
-
-	for _, svc := range m.Services {
+	for i := len(m.Services) - 1; i >= 0; i-- {
+		svc := m.Services[i]
 		if svc.Unit.Noop {
 			m.logger.Debug("skipped stopping service",
 				zap.String("service_name", svc.Unit.Name),
@@ -138,10 +326,174 @@ func (m *Manager) Stop() []*Status {
 			continue
 		}
 		if err := svc.Stop(); err != nil {
-			svcErrors = append(svcErrors, svc.Status)
+			svcErrors = append(svcErrors, svc.GetStatus())
+		}
+	}
+	return svcErrors
+}
+
+// StopService stops the service with the provided name. Prior to that, it
+// stops the services requiring it.
+func (m *Manager) StopService(name string) []*Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var found bool
+	for _, svc := range m.Services {
+		if svc.Unit.Name == name {
+			found = true
+			break
 		}
 	}
+	if !found {
+		return []*Status{
+			{
+				Current:     FailureStatus,
+				ServiceName: name,
+				Error:       ErrServiceNotFound,
+			}}
+	}
 
-	m.started = false
+	targets := m.requiredBy(name)
+	targets[name] = true
+
+	svcErrors := []*Status{}
+	for i := len(m.Services) - 1; i >= 0; i-- {
+		svc := m.Services[i]
+		if !targets[svc.Unit.Name] || svc.Unit.Noop {
+			continue
+		}
+		if err := svc.Stop(); err != nil {
+			svcErrors = append(svcErrors, svc.GetStatus())
+		}
+	}
 	return svcErrors
 }
+
+// ReloadService reloads the service with the provided name.
+func (m *Manager) ReloadService(name string) []*Status {
+	// The reload command may run for a while, so the lock is released
+	// prior to the reload to keep the manager responsive.
+	m.mu.Lock()
+	var svc *Service
+	for _, s := range m.Services {
+		if s.Unit.Name == name {
+			svc = s
+			break
+		}
+	}
+	m.mu.Unlock()
+
+	if svc == nil {
+		return []*Status{
+			{
+				Current:     FailureStatus,
+				ServiceName: name,
+				Error:       ErrServiceNotFound,
+			}}
+	}
+	if err := svc.Reload(); err != nil {
+		return []*Status{
+			{
+				Current:     FailureStatus,
+				ServiceName: name,
+				Error:       err,
+			}}
+	}
+	return nil
+}
+
+// handleExit stops the services requiring the service that exited
+// unexpectedly. When the service exits while the services start, the
+// services requiring it do not start, and the ones already started stop
+// once the start completes.
+func (m *Manager) handleExit(svc *Service) {
+	m.exitMu.Lock()
+	m.exited[svc.Unit.Name] = true
+	m.exitMu.Unlock()
+	go func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		targets := m.requiredBy(svc.Unit.Name)
+		for i := len(m.Services) - 1; i >= 0; i-- {
+			dep := m.Services[i]
+			if !targets[dep.Unit.Name] || dep.Unit.Noop {
+				continue
+			}
+			m.logger.Debug("stopping service requiring exited service",
+				zap.String("service_name", dep.Unit.Name),
+				zap.String("required_service_name", svc.Unit.Name),
+			)
+			if err := dep.Stop(); err != nil {
+				m.logger.Error("failed stopping service",
+					zap.String("service_name", dep.Unit.Name),
+					zap.Error(err),
+				)
+			}
+		}
+	}()
+}
+
+// requiredBy returns the names of the services that require the service
+// with the provided name, directly or transitively.
+func (m *Manager) requiredBy(name string) map[string]bool {
+	names := make(map[string]bool)
+	queue := []string{name}
+	for len(queue) > 0 {
+		dep := queue[0]
+		queue = queue[1:]
+		for _, svc := range m.Services {
+			if names[svc.Unit.Name] {
+				continue
+			}
+			for _, req := range svc.Unit.Requires {
+				if req == dep {
+					names[svc.Unit.Name] = true
+					queue = append(queue, svc.Unit.Name)
+					break
+				}
+			}
+		}
+	}
+	return names
+}
+
+func checkRequirements(svc *Service, inactive map[string]bool) error {
+	for _, dep := range svc.Unit.Requires {
+		if inactive[dep] {
+			return fmt.Errorf("required unit %q is not active", dep)
+		}
+	}
+	return nil
+}
+
+func validateOutputFiles(u *Unit) error {
+	if u.StdOutFilePath != "" {
+		if err := validateFilePath(u.StdOutFilePath); err != nil {
+			return err
+		}
+	}
+	if u.StdErrFilePath != "" {
+		if err := validateFilePath(u.StdErrFilePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateWorkDirectory(u *Unit) error {
+	if u.WorkDirectory == "" {
+		return nil
+	}
+	fi, err := os.Stat(u.WorkDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("working directory does not exist: %s", u.WorkDirectory)
+		}
+		return fmt.Errorf("working directory erred: %s", u.WorkDirectory)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("working directory is not a directory: %s", u.WorkDirectory)
+	}
+	return nil
+}
@@ -0,0 +1,447 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+)
+
+func TestManagerStart(t *testing.T) {
+	testcases := []struct {
+		name  string
+		units []*Unit
+		want  map[string]StatusKind
+		// The names of the services in the returned errors.
+		failed []string
+	}{
+		{
+			name: "test start units with requires and wants directives",
+			units: []*Unit{
+				{
+					Name:    "broken",
+					Command: "false",
+					Kind:    "command",
+				},
+				{
+					Name:     "requires-broken",
+					Command:  "true",
+					Kind:     "command",
+					Requires: []string{"broken"},
+				},
+				{
+					Name:     "requires-requires-broken",
+					Command:  "true",
+					Kind:     "command",
+					Requires: []string{"requires-broken"},
+				},
+				{
+					Name:    "wants-broken",
+					Command: "true",
+					Kind:    "command",
+					Wants:   []string{"broken"},
+				},
+				{
+					Name:    "disabled",
+					Command: "true",
+					Kind:    "command",
+					Noop:    true,
+				},
+				{
+					Name:    "wants-disabled",
+					Command: "true",
+					Kind:    "command",
+					Wants:   []string{"disabled"},
+				},
+			},
+			want: map[string]StatusKind{
+				"broken":                   FailureStatus,
+				"requires-broken":          DependencyFailedStatus,
+				"requires-requires-broken": DependencyFailedStatus,
+				"wants-broken":             SuccessStatus,
+				"disabled":                 PendingStatus,
+				"wants-disabled":           SuccessStatus,
+			},
+			failed: []string{"broken", "requires-broken", "requires-requires-broken"},
+		},
+		{
+			name: "test start units with failed wanted unit",
+			units: []*Unit{
+				{
+					Name:    "broken",
+					Command: "false",
+					Kind:    "command",
+				},
+				{
+					Name:     "requires-broken",
+					Command:  "true",
+					Kind:     "command",
+					Requires: []string{"broken"},
+					Wants:    []string{"broken"},
+				},
+				{
+					Name:    "optional",
+					Command: "false",
+					Kind:    "command",
+				},
+				{
+					Name:    "wants-optional",
+					Command: "true",
+					Kind:    "command",
+					Wants:   []string{"optional"},
+				},
+			},
+			want: map[string]StatusKind{
+				"broken":          FailureStatus,
+				"requires-broken": DependencyFailedStatus,
+				"optional":        FailureStatus,
+				"wants-optional":  SuccessStatus,
+			},
+			failed: []string{"broken", "requires-broken"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := NewConfig()
+			for _, u := range tc.units {
+				if err := cfg.AddUnit(u); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m, err := NewManager(cfg, zap.NewNop())
+			if err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			var failed []string
+			for _, st := range m.Start() {
+				failed = append(failed, st.ServiceName)
+			}
+			defer m.Stop()
+			if diff := cmp.Diff(tc.failed, failed); diff != "" {
+				t.Errorf("failed services mismatch (-want +got):\n%s", diff)
+			}
+
+			got := make(map[string]StatusKind)
+			for _, svc := range m.Services {
+				got[svc.Unit.Name] = svc.GetStatus().Current
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("status mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestManagerStartFailure(t *testing.T) {
+	cfg := NewConfig()
+	for _, u := range []*Unit{
+		{Name: "database", Command: "sleep", Arguments: []string{"60"}, Kind: "app"},
+		{Name: "migrator", Command: "false", Kind: "command", After: []string{"database"}},
+	} {
+		if err := cfg.AddUnit(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := NewManager(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	msgs := m.Start()
+	if len(msgs) != 1 || msgs[0].ServiceName != "migrator" {
+		t.Fatalf("unexpected start errors: %v", msgs)
+	}
+
+	database := m.Services[0]
+	if database.worker != nil {
+		t.Errorf("expected database to stop after failed start")
+	}
+	if state := database.GetState(); state.Current != CompletedState {
+		t.Errorf("unexpected database state: %v", state.Current)
+	}
+}
+
+func TestManagerStopService(t *testing.T) {
+	cfg := NewConfig()
+	for _, u := range []*Unit{
+		{Name: "database", Command: "sleep", Arguments: []string{"60"}, Kind: "app"},
+		{Name: "webapp", Command: "sleep", Arguments: []string{"60"}, Kind: "app", Requires: []string{"database"}},
+		{Name: "worker", Command: "sleep", Arguments: []string{"60"}, Kind: "app", After: []string{"database"}},
+	} {
+		if err := cfg.AddUnit(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := NewManager(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if msgs := m.Start(); msgs != nil {
+		t.Fatalf("expected success, got: %v", msgs[0].Error)
+	}
+	defer m.Stop()
+
+	if msgs := m.StopService("database"); len(msgs) > 0 {
+		t.Fatalf("expected success, got: %v", msgs[0].Error)
+	}
+
+	got := make(map[string]bool)
+	for _, svc := range m.Services {
+		got[svc.Unit.Name] = svc.worker != nil
+	}
+	want := map[string]bool{
+		"database": false,
+		"webapp":   false,
+		"worker":   true,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("running services mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestManagerParallelStart(t *testing.T) {
+	testcases := []struct {
+		name              string
+		maxParallelStarts int
+		minDuration       time.Duration
+		maxDuration       time.Duration
+	}{
+		{
+			name:        "test start independent units concurrently",
+			maxDuration: 900 * time.Millisecond,
+		},
+		{
+			name:              "test start independent units serially",
+			maxParallelStarts: 1,
+			minDuration:       900 * time.Millisecond,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := NewConfig()
+			cfg.MaxParallelStarts = tc.maxParallelStarts
+			for _, name := range []string{"foo", "bar", "baz"} {
+				u := &Unit{Name: name, Command: "sleep", Arguments: []string{"0.3"}, Kind: "command"}
+				if err := cfg.AddUnit(u); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m, err := NewManager(cfg, zap.NewNop())
+			if err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			startedAt := time.Now()
+			if msgs := m.Start(); msgs != nil {
+				t.Fatalf("expected success, got: %v", msgs[0].Error)
+			}
+			elapsed := time.Since(startedAt)
+			if elapsed < tc.minDuration {
+				t.Errorf("start took %v, want at least %v", elapsed, tc.minDuration)
+			}
+			if tc.maxDuration > 0 && elapsed > tc.maxDuration {
+				t.Errorf("start took %v, want at most %v", elapsed, tc.maxDuration)
+			}
+			m.Stop()
+		})
+	}
+}
+
+func TestManagerStartOrder(t *testing.T) {
+	testcases := []struct {
+		name              string
+		maxParallelStarts int
+		units             []*Unit
+		want              []string
+	}{
+		{
+			name:              "test start units by priority",
+			maxParallelStarts: 1,
+			units: []*Unit{
+				{Name: "alpha"},
+				{Name: "beta", Priority: 100},
+				{Name: "gamma", Priority: 10},
+				{Name: "delta"},
+			},
+			want: []string{"beta", "gamma", "alpha", "delta"},
+		},
+		{
+			name:              "test start units by priority within layer",
+			maxParallelStarts: 1,
+			units: []*Unit{
+				{Name: "database", Priority: 10},
+				{Name: "alpha", After: []string{"database"}},
+				{Name: "beta", After: []string{"database"}, Priority: 100},
+				{Name: "cache", Priority: 1},
+			},
+			want: []string{"database", "cache", "beta", "alpha"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			fp := filepath.Join(t.TempDir(), "order.log")
+			cfg := NewConfig()
+			cfg.MaxParallelStarts = tc.maxParallelStarts
+			for _, u := range tc.units {
+				u.Kind = "command"
+				u.Command = "sh"
+				u.Arguments = []string{"-c", "echo " + u.Name + " >> " + fp}
+				if err := cfg.AddUnit(u); err != nil {
+					t.Fatal(err)
+				}
+			}
+			m, err := NewManager(cfg, zap.NewNop())
+			if err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			if msgs := m.Start(); msgs != nil {
+				t.Fatalf("expected success, got: %v", msgs[0].Error)
+			}
+			defer m.Stop()
+			b, err := os.ReadFile(fp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, strings.Fields(string(b))); diff != "" {
+				t.Errorf("unexpected start order (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestManagerReloadService(t *testing.T) {
+	cfg := NewConfig()
+	for _, u := range []*Unit{
+		{Name: "database", Command: "sleep", Arguments: []string{"60"}, Kind: "app"},
+		{
+			Name:       "webapp",
+			Command:    "sleep",
+			Arguments:  []string{"60"},
+			Kind:       "app",
+			ExecReload: &Exec{Command: "sleep", Arguments: []string{"1"}},
+		},
+	} {
+		if err := cfg.AddUnit(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := NewManager(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if msgs := m.Start(); msgs != nil {
+		t.Fatalf("expected success, got: %v", msgs[0].Error)
+	}
+	defer m.Stop()
+
+	if msgs := m.ReloadService("cache"); len(msgs) != 1 || msgs[0].Error != ErrServiceNotFound {
+		t.Fatalf("unexpected reload result: %v", msgs)
+	}
+
+	reloaded := make(chan []*Status)
+	go func() {
+		reloaded <- m.ReloadService("webapp")
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	// The manager remains responsive while the reload command runs.
+	startedAt := time.Now()
+	if msgs := m.StopService("database"); len(msgs) > 0 {
+		t.Fatalf("expected success, got: %v", msgs[0].Error)
+	}
+	if elapsed := time.Since(startedAt); elapsed > 500*time.Millisecond {
+		t.Errorf("stop took %v while reloading another service", elapsed)
+	}
+	if msgs := <-reloaded; len(msgs) > 0 {
+		t.Fatalf("expected success, got: %v", msgs[0].Error)
+	}
+}
+
+func TestManagerUnexpectedExit(t *testing.T) {
+	cfg := NewConfig()
+	for _, u := range []*Unit{
+		{Name: "database", Command: "sh", Arguments: []string{"-c", "sleep 0.2; exit 3"}, Kind: "app"},
+		{Name: "webapp", Command: "sleep", Arguments: []string{"60"}, Kind: "app", Requires: []string{"database"}},
+	} {
+		if err := cfg.AddUnit(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := NewManager(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if msgs := m.Start(); msgs != nil {
+		t.Fatalf("expected success, got: %v", msgs[0].Error)
+	}
+	defer m.Stop()
+
+	database, webapp := m.Services[0], m.Services[1]
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if webapp.GetState().Current == CompletedState {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	state := database.GetState()
+	if state.Current != StoppedState {
+		t.Errorf("unexpected database state: %v", state.Current)
+	}
+	if state.ExitCode != 3 {
+		t.Errorf("unexpected database exit code: %d", state.ExitCode)
+	}
+	if status := database.GetStatus(); status.Current != FailureStatus || status.Error == nil {
+		t.Errorf("unexpected database status: %v, %v", status.Current, status.Error)
+	}
+	if state := webapp.GetState(); state.Current != CompletedState {
+		t.Errorf("unexpected webapp state: %v", state.Current)
+	}
+}
+
+func TestManagerExitDuringStart(t *testing.T) {
+	cfg := NewConfig()
+	for _, u := range []*Unit{
+		{Name: "database", Command: "sh", Arguments: []string{"-c", "sleep 0.1; exit 3"}, Kind: "app"},
+		{Name: "migrator", Command: "sleep", Arguments: []string{"0.5"}, Kind: "command", After: []string{"database"}},
+		{Name: "webapp", Command: "sleep", Arguments: []string{"60"}, Kind: "app", Requires: []string{"database"}, After: []string{"migrator"}},
+	} {
+		if err := cfg.AddUnit(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := NewManager(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	msgs := m.Start()
+	defer m.Stop()
+	if len(msgs) != 1 || msgs[0].ServiceName != "webapp" {
+		t.Fatalf("unexpected start errors: %v", msgs)
+	}
+
+	webapp := m.Services[2]
+	if webapp.worker != nil {
+		t.Errorf("expected webapp not to start")
+	}
+	if status := webapp.GetStatus(); status.Current != DependencyFailedStatus {
+		t.Errorf("unexpected webapp status: %v", status.Current)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The supported service types.
+const (
+	// SimpleServiceType is an app considered started once its process starts.
+	SimpleServiceType = "simple"
+	// NotifyServiceType is an app considered started once it sends READY=1
+	// message via sd_notify protocol.
+	NotifyServiceType = "notify"
+)
+
+// notifyMessage is a message received via sd_notify protocol.
+type notifyMessage struct {
+	Ready    bool
+	Stopping bool
+	Status   string
+	MainPid  int
+	Errno    int
+	// The watchdog message: 1 for keep-alive, trigger for immediate
+	// watchdog timeout.
+	Watchdog string
+}
+
+// parseNotifyMessage parses newline-separated KEY=VALUE assignments.
+func parseNotifyMessage(b []byte) *notifyMessage {
+	msg := &notifyMessage{}
+	for _, line := range strings.Split(string(b), "\n") {
+		k, v, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		switch k {
+		case "READY":
+			msg.Ready = v == "1"
+		case "STOPPING":
+			msg.Stopping = v == "1"
+		case "STATUS":
+			msg.Status = v
+		case "MAINPID":
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				msg.MainPid = n
+			}
+		case "WATCHDOG":
+			if v == "1" || v == "trigger" {
+				msg.Watchdog = v
+			}
+		case "ERRNO":
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				msg.Errno = n
+			}
+		}
+	}
+	return msg
+}
+
+// notifier is a unix datagram socket receiving sd_notify messages from an
+// app. The path to the socket is passed to the app in NOTIFY_SOCKET
+// environment variable.
+type notifier struct {
+	dir  string
+	path string
+	conn *net.UnixConn
+	// The channel closed when the app sends READY=1 message.
+	ready     chan struct{}
+	readyOnce sync.Once
+	// The channel receiving the watchdog messages.
+	watchdog chan string
+}
+
+func newNotifier() (*notifier, error) {
+	dir, err := os.MkdirTemp("", "appd-notify-")
+	if err != nil {
+		return nil, err
+	}
+	n := &notifier{
+		dir:      dir,
+		path:     filepath.Join(dir, "notify.sock"),
+		ready:    make(chan struct{}),
+		watchdog: make(chan string, 1),
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: n.path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	n.conn = conn
+	return n, nil
+}
+
+// serve reads the messages until the socket closes.
+func (n *notifier) serve(handler func(*notifyMessage)) {
+	buf := make([]byte, 4096)
+	for {
+		size, _, err := n.conn.ReadFromUnix(buf)
+		if err != nil {
+			return
+		}
+		msg := parseNotifyMessage(buf[:size])
+		if handler != nil {
+			handler(msg)
+		}
+		if msg.Ready {
+			n.readyOnce.Do(func() { close(n.ready) })
+		}
+		if msg.Watchdog != "" {
+			select {
+			case n.watchdog <- msg.Watchdog:
+			default:
+				// The pending keep-alive has not been consumed yet.
+			}
+		}
+	}
+}
+
+// chown makes the socket accessible to the app running as another user.
+func (n *notifier) chown(uid, gid int) error {
+	if err := os.Chown(n.dir, uid, gid); err != nil {
+		return err
+	}
+	return os.Chown(n.path, uid, gid)
+}
+
+func (n *notifier) close() {
+	n.conn.Close()
+	os.RemoveAll(n.dir)
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+)
+
+func TestParseNotifyMessage(t *testing.T) {
+	testcases := []struct {
+		name  string
+		input string
+		want  *notifyMessage
+	}{
+		{
+			name:  "test ready message",
+			input: "READY=1",
+			want:  &notifyMessage{Ready: true},
+		},
+		{
+			name:  "test multi-line message",
+			input: "READY=1\nSTATUS=Processing requests\nMAINPID=1234\n",
+			want:  &notifyMessage{Ready: true, Status: "Processing requests", MainPid: 1234},
+		},
+		{
+			name:  "test stopping message with errno",
+			input: "STOPPING=1\nERRNO=2",
+			want:  &notifyMessage{Stopping: true, Errno: 2},
+		},
+		{
+			name:  "test message with invalid values",
+			input: "READY=0\nMAINPID=abc\nERRNO=-1\nWATCHDOG",
+			want:  &notifyMessage{},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseNotifyMessage([]byte(tc.input))
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("parseNotifyMessage() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// notifyScript sends the provided sd_notify messages to NOTIFY_SOCKET.
+const notifyScript = `
+import os, socket, sys, time
+s = socket.socket(socket.AF_UNIX, socket.SOCK_DGRAM)
+for msg in sys.argv[1:]:
+    time.sleep(0.2)
+    s.sendto(msg.encode(), os.environ["NOTIFY_SOCKET"])
+time.sleep(60)
+`
+
+func TestServiceNotify(t *testing.T) {
+	testcases := []struct {
+		name        string
+		unit        *Unit
+		minDuration time.Duration
+		want        *Status
+		shouldErr   bool
+		err         string
+	}{
+		{
+			name: "test notify app becomes ready",
+			unit: &Unit{
+				Name:        "webapp",
+				Kind:        "app",
+				ServiceType: NotifyServiceType,
+				Command:     "python3",
+				Arguments:   []string{"-c", notifyScript, "STATUS=Starting", "READY=1\nSTATUS=Serving"},
+			},
+			minDuration: 400 * time.Millisecond,
+			want:        &Status{Current: SuccessStatus, ServiceName: "webapp", Message: "Serving"},
+		},
+		{
+			name: "test notify app never becomes ready",
+			unit: &Unit{
+				Name:         "webapp",
+				Kind:         "app",
+				ServiceType:  NotifyServiceType,
+				Command:      "python3",
+				Arguments:    []string{"-c", notifyScript, "STATUS=Starting"},
+				ReadyTimeout: Duration(500 * time.Millisecond),
+			},
+			shouldErr: true,
+			err:       "app did not send READY=1 within 500ms",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.unit.validate(); err != nil {
+				t.Fatal(err)
+			}
+			svc, err := NewService(0, tc.unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			startedAt := time.Now()
+			err = svc.Start()
+			defer svc.Stop()
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if elapsed := time.Since(startedAt); elapsed < tc.minDuration {
+				t.Errorf("start took %v, want at least %v", elapsed, tc.minDuration)
+			}
+			if diff := cmp.Diff(tc.want, svc.GetStatus()); diff != "" {
+				t.Errorf("GetStatus() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnitServiceType(t *testing.T) {
+	u := &Unit{Name: "hostname", Kind: "command", Command: "hostname", ServiceType: NotifyServiceType}
+	if err := u.validate(); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
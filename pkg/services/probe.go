@@ -0,0 +1,175 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultProbeInterval = time.Second
+	defaultProbeTimeout  = 3 * time.Second
+	defaultReadyTimeout  = 90 * time.Second
+	// The default number of consecutive health check failures prior to
+	// considering an app unhealthy.
+	defaultProbeFailureThreshold = 3
+	// The maximum length of the probe output recorded in the status.
+	maxProbeOutputLength = 1024
+)
+
+// The supported probe types.
+const (
+	TCPProbe  = "tcp"
+	HTTPProbe = "http"
+	UnixProbe = "unix"
+	ExecProbe = "exec"
+)
+
+// Probe is a check of an app.
+type Probe struct {
+	// The type of the probe: tcp, http, unix, or exec.
+	Kind string `json:"kind,omitempty"`
+	// The host:port of tcp probe, the URL of http probe, the socket path of
+	// unix probe, or the command of exec probe.
+	Target string `json:"target,omitempty"`
+	// The arguments of exec probe.
+	Arguments []string `json:"args,omitempty"`
+	// The expected status code of http probe. If zero, any 2xx or 3xx status
+	// code is a success.
+	ExpectedStatus int `json:"expected_status,omitempty"`
+	// The interval between the probes.
+	Interval Duration `json:"interval,omitempty"`
+	// The timeout of a probe.
+	Timeout Duration `json:"timeout,omitempty"`
+	// The number of consecutive failures of health probe prior to
+	// considering an app unhealthy.
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+}
+
+func (p *Probe) validate() error {
+	switch p.Kind {
+	case TCPProbe, HTTPProbe, UnixProbe, ExecProbe:
+	case "":
+		return fmt.Errorf("empty probe type")
+	default:
+		return fmt.Errorf("invalid %q probe type", p.Kind)
+	}
+	if p.Target == "" {
+		return fmt.Errorf("empty %s probe target", p.Kind)
+	}
+	if p.Kind != ExecProbe && len(p.Arguments) > 0 {
+		return fmt.Errorf("%s probe does not support arguments", p.Kind)
+	}
+	if p.Kind != HTTPProbe && p.ExpectedStatus != 0 {
+		return fmt.Errorf("%s probe does not support expected status", p.Kind)
+	}
+	if p.FailureThreshold < 0 {
+		return fmt.Errorf("negative probe failure threshold")
+	}
+	return nil
+}
+
+func (p *Probe) failureThreshold() int {
+	if p.FailureThreshold > 0 {
+		return p.FailureThreshold
+	}
+	return defaultProbeFailureThreshold
+}
+
+func (p *Probe) interval() time.Duration {
+	if p.Interval > 0 {
+		return time.Duration(p.Interval)
+	}
+	return defaultProbeInterval
+}
+
+func (p *Probe) timeout() time.Duration {
+	if p.Timeout > 0 {
+		return time.Duration(p.Timeout)
+	}
+	return defaultProbeTimeout
+}
+
+// check runs the probe once. It returns the output of the probe and an
+// error when the probe fails.
+func (p *Probe) check(w *worker) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+
+	switch p.Kind {
+	case TCPProbe, UnixProbe:
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, p.Kind, p.Target)
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return "", nil
+	case HTTPProbe:
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Target, nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxProbeOutputLength))
+		output := truncateProbeOutput(string(body))
+		if p.ExpectedStatus != 0 {
+			if resp.StatusCode != p.ExpectedStatus {
+				return output, fmt.Errorf("unexpected status code %d, expected %d", resp.StatusCode, p.ExpectedStatus)
+			}
+			return output, nil
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 399 {
+			return output, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		return output, nil
+	case ExecProbe:
+		// The command runs the way the other commands of the unit do, in
+		// the namespaces of the app.
+		cmd, err := newAdhocCommand(ctx, w.unit, w, p.Target, p.Arguments, w.execEnv())
+		if err != nil {
+			return "", err
+		}
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		err = runAdhocCommand(cmd, w.unit)
+		output := truncateProbeOutput(out.String())
+		if ctx.Err() != nil {
+			return output, fmt.Errorf("probe timed out after %s", p.timeout())
+		}
+		return output, err
+	}
+	return "", fmt.Errorf("invalid %q probe type", p.Kind)
+}
+
+func truncateProbeOutput(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxProbeOutputLength {
+		return s[:maxProbeOutputLength]
+	}
+	return s
+}
@@ -0,0 +1,320 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestProbeCheck(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+
+	unixSocketPath := filepath.Join(t.TempDir(), "app.sock")
+	unixListener, err := net.Listen("unix", unixSocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixListener.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/unhealthy" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("database is down"))
+			return
+		}
+		w.Write([]byte("OK"))
+	}))
+	defer srv.Close()
+
+	testcases := []struct {
+		name      string
+		probe     *Probe
+		want      string
+		shouldErr bool
+	}{
+		{
+			name:  "test tcp probe",
+			probe: &Probe{Kind: TCPProbe, Target: tcpListener.Addr().String()},
+		},
+		{
+			name:      "test tcp probe with closed port",
+			probe:     &Probe{Kind: TCPProbe, Target: "127.0.0.1:1"},
+			shouldErr: true,
+		},
+		{
+			name:  "test unix probe",
+			probe: &Probe{Kind: UnixProbe, Target: unixSocketPath},
+		},
+		{
+			name:  "test http probe",
+			probe: &Probe{Kind: HTTPProbe, Target: srv.URL + "/health"},
+			want:  "OK",
+		},
+		{
+			name:      "test http probe with unexpected status",
+			probe:     &Probe{Kind: HTTPProbe, Target: srv.URL + "/unhealthy"},
+			want:      "database is down",
+			shouldErr: true,
+		},
+		{
+			name:  "test http probe with expected status",
+			probe: &Probe{Kind: HTTPProbe, Target: srv.URL + "/unhealthy", ExpectedStatus: 503},
+			want:  "database is down",
+		},
+		{
+			name:  "test exec probe",
+			probe: &Probe{Kind: ExecProbe, Target: "echo", Arguments: []string{"ready"}},
+			want:  "ready",
+		},
+		{
+			name:      "test failed exec probe",
+			probe:     &Probe{Kind: ExecProbe, Target: "sh", Arguments: []string{"-c", "echo not ready; exit 1"}},
+			want:      "not ready",
+			shouldErr: true,
+		},
+		{
+			name:      "test exec probe timeout",
+			probe:     &Probe{Kind: ExecProbe, Target: "sleep", Arguments: []string{"5"}, Timeout: Duration(100 * time.Millisecond)},
+			shouldErr: true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.probe.validate(); err != nil {
+				t.Fatalf("unexpected validation error: %v", err)
+			}
+			got, err := tc.probe.check(&worker{unit: &Unit{Name: "webapp"}})
+			if (err != nil) != tc.shouldErr {
+				t.Fatalf("unexpected probe result: %v, want error: %v", err, tc.shouldErr)
+			}
+			if got != tc.want {
+				t.Errorf("unexpected probe output: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServiceExecProbeEnvironment(t *testing.T) {
+	// The probe must not see the environment of Caddy.
+	t.Setenv("CADDY_SECRET", "caddy")
+	testcases := []struct {
+		name string
+		user string
+		uid  int
+	}{
+		{
+			name: "test exec probe with unit environment",
+			uid:  os.Geteuid(),
+		},
+		{
+			name: "test exec probe with unit credential",
+			user: "nobody",
+			uid:  65534,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.user != "" && os.Geteuid() != 0 {
+				t.Skip("requires root privileges")
+			}
+			// The user must be able to reach the working directory.
+			dir := t.TempDir()
+			for _, p := range []string{filepath.Dir(dir), dir} {
+				if err := os.Chmod(p, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			script := fmt.Sprintf(`test "$APP_SECRET" = app && test -z "$CADDY_SECRET" && test "$(id -u)" = %d && test "$PWD" = %s`, tc.uid, dir)
+			unit := &Unit{
+				Name:           "webapp",
+				Kind:           "app",
+				Command:        "sleep",
+				Arguments:      []string{"60"},
+				WorkDirectory:  dir,
+				Environment:    map[string]string{"APP_SECRET": "app"},
+				EnvPassthrough: []string{"PATH"},
+				User:           tc.user,
+				ReadyProbe: &Probe{
+					Kind:      ExecProbe,
+					Target:    "sh",
+					Arguments: []string{"-c", script},
+					Interval:  Duration(50 * time.Millisecond),
+				},
+				ReadyTimeout: Duration(time.Second),
+			}
+			if err := unit.validate(); err != nil {
+				t.Fatal(err)
+			}
+			if err := unit.resolveCredential(); err != nil {
+				t.Fatal(err)
+			}
+			svc, err := NewService(0, unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = svc.Start()
+			defer svc.Stop()
+			if err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestServiceReadiness(t *testing.T) {
+	readyFilePath := filepath.Join(t.TempDir(), "ready")
+
+	testcases := []struct {
+		name        string
+		unit        *Unit
+		minDuration time.Duration
+		shouldErr   bool
+		err         string
+	}{
+		{
+			name: "test app becomes ready",
+			unit: &Unit{
+				Name:      "webapp",
+				Kind:      "app",
+				Command:   "sh",
+				Arguments: []string{"-c", "sleep 0.3; touch " + readyFilePath + "; exec sleep 60"},
+				ReadyProbe: &Probe{
+					Kind:      ExecProbe,
+					Target:    "test",
+					Arguments: []string{"-f", readyFilePath},
+					Interval:  Duration(50 * time.Millisecond),
+				},
+			},
+			minDuration: 300 * time.Millisecond,
+		},
+		{
+			name: "test app never becomes ready",
+			unit: &Unit{
+				Name:         "webapp",
+				Kind:         "app",
+				Command:      "sleep",
+				Arguments:    []string{"60"},
+				ReadyProbe:   &Probe{Kind: TCPProbe, Target: "127.0.0.1:1", Interval: Duration(50 * time.Millisecond)},
+				ReadyTimeout: Duration(300 * time.Millisecond),
+			},
+			shouldErr: true,
+			err:       "readiness probe failed within 300ms",
+		},
+		{
+			name: "test app exits prior to becoming ready",
+			unit: &Unit{
+				Name:       "webapp",
+				Kind:       "app",
+				Command:    "sh",
+				Arguments:  []string{"-c", "sleep 0.1; exit 2"},
+				Restart:    RestartAlways,
+				ReadyProbe: &Probe{Kind: TCPProbe, Target: "127.0.0.1:1", Interval: Duration(50 * time.Millisecond)},
+			},
+			shouldErr: true,
+			err:       "process exited prior to becoming ready: exit code 2",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc, err := NewService(0, tc.unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			startedAt := time.Now()
+			err = svc.Start()
+			defer svc.Stop()
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+				}
+				if state := svc.GetState(); state.Current != CompletedState || state.Restarts != 0 {
+					t.Fatalf("unexpected state: %v, restarts: %d", state.Current, state.Restarts)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if elapsed := time.Since(startedAt); elapsed < tc.minDuration {
+				t.Errorf("start took %v, want at least %v", elapsed, tc.minDuration)
+			}
+			if state := svc.GetState(); state.Current != RunningState {
+				t.Errorf("unexpected state: %v", state.Current)
+			}
+		})
+	}
+}
+
+func TestServiceHealthCheck(t *testing.T) {
+	unit := &Unit{
+		Name:         "webapp",
+		Kind:         "app",
+		Command:      "sleep",
+		Arguments:    []string{"60"},
+		Restart:      RestartOnAbnormal,
+		RestartDelay: Duration(time.Minute),
+		HealthProbe: &Probe{
+			Kind:             ExecProbe,
+			Target:           "sh",
+			Arguments:        []string{"-c", "echo database is down; exit 1"},
+			Interval:         Duration(50 * time.Millisecond),
+			FailureThreshold: 2,
+		},
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	defer svc.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && svc.GetState().Current != StoppedState {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	status := svc.GetStatus()
+	if status.Current != FailureStatus || status.Error == nil || !strings.Contains(status.Error.Error(), "health check failed 2 times") {
+		t.Fatalf("unexpected status: %v, %v", status.Current, status.Error)
+	}
+	if status.ProbeOutput != "database is down" {
+		t.Errorf("unexpected probe output: %q", status.ProbeOutput)
+	}
+	svc.mu.Lock()
+	scheduled := svc.restartTimer != nil
+	svc.mu.Unlock()
+	if !scheduled {
+		t.Errorf("expected scheduled restart of unhealthy service")
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package services
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op. Process groups are not supported on Windows.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup sends the signal to the process with the provided ID.
+func signalGroup(pid int, sig os.Signal) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
+
+// groupAlive returns false. Process groups are not supported on Windows.
+func groupAlive(pgid int) bool {
+	return false
+}
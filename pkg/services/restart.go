@@ -0,0 +1,125 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	defaultRestartDelay       = time.Second
+	defaultRestartMaxDelay    = time.Minute
+	defaultStartLimitBurst    = 5
+	defaultStartLimitInterval = 10 * time.Second
+)
+
+// The supported restart policies.
+const (
+	RestartNo         = "no"
+	RestartAlways     = "always"
+	RestartOnFailure  = "on-failure"
+	RestartOnAbnormal = "on-abnormal"
+)
+
+func validateRestartPolicy(policy string) error {
+	switch policy {
+	case "", RestartNo, RestartAlways, RestartOnFailure, RestartOnAbnormal:
+		return nil
+	}
+	return fmt.Errorf("invalid restart policy: %q", policy)
+}
+
+// shouldRestart returns true when the restart policy requires restarting
+// the application after the provided exit.
+func shouldRestart(policy string, exit *exitStatus) bool {
+	switch policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return !exit.clean()
+	case RestartOnAbnormal:
+		return exit.abnormal()
+	}
+	return false
+}
+
+// restartDelay returns the delay prior to the restart following the
+// provided number of consecutive restarts.
+func restartDelay(delay, maxDelay time.Duration, restarts int) time.Duration {
+	for i := 0; i < restarts && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+func (u *Unit) restartDelay() time.Duration {
+	if u.RestartDelay > 0 {
+		return time.Duration(u.RestartDelay)
+	}
+	return defaultRestartDelay
+}
+
+func (u *Unit) restartMaxDelay() time.Duration {
+	if u.RestartMaxDelay > 0 {
+		return time.Duration(u.RestartMaxDelay)
+	}
+	if u.restartDelay() > defaultRestartMaxDelay {
+		return u.restartDelay()
+	}
+	return defaultRestartMaxDelay
+}
+
+func (u *Unit) startLimitBurst() int {
+	if u.StartLimitBurst > 0 {
+		return u.StartLimitBurst
+	}
+	return defaultStartLimitBurst
+}
+
+func (u *Unit) startLimitInterval() time.Duration {
+	if u.StartLimitInterval > 0 {
+		return time.Duration(u.StartLimitInterval)
+	}
+	return defaultStartLimitInterval
+}
+
+// startLimiter tracks the restarts of an application and detects crash
+// loops.
+type startLimiter struct {
+	burst    int
+	interval time.Duration
+	restarts []time.Time
+}
+
+// allow records a restart at the provided time. It returns an error when
+// the number of restarts within the interval exceeds the burst.
+func (l *startLimiter) allow(t time.Time) error {
+	var restarts []time.Time
+	for _, ts := range l.restarts {
+		if t.Sub(ts) < l.interval {
+			restarts = append(restarts, ts)
+		}
+	}
+	if len(restarts) >= l.burst {
+		l.restarts = restarts
+		return fmt.Errorf("crash loop detected: %d restarts within %s", len(restarts), l.interval)
+	}
+	l.restarts = append(restarts, t)
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRestartDelay(t *testing.T) {
+	testcases := []struct {
+		name     string
+		restarts int
+		want     time.Duration
+	}{
+		{name: "test first restart", restarts: 0, want: time.Second},
+		{name: "test second restart", restarts: 1, want: 2 * time.Second},
+		{name: "test fourth restart", restarts: 3, want: 8 * time.Second},
+		{name: "test restart capped by max delay", restarts: 10, want: 30 * time.Second},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := restartDelay(time.Second, 30*time.Second, tc.restarts)
+			if got != tc.want {
+				t.Errorf("unexpected delay: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	clean := &exitStatus{Code: 0}
+	failed := &exitStatus{Code: 1}
+	killed := &exitStatus{Code: -1, Signal: "killed"}
+
+	testcases := []struct {
+		policy string
+		want   []bool
+	}{
+		{policy: "", want: []bool{false, false, false}},
+		{policy: RestartNo, want: []bool{false, false, false}},
+		{policy: RestartAlways, want: []bool{true, true, true}},
+		{policy: RestartOnFailure, want: []bool{false, true, true}},
+		{policy: RestartOnAbnormal, want: []bool{false, false, true}},
+	}
+	for _, tc := range testcases {
+		t.Run("test restart policy "+tc.policy, func(t *testing.T) {
+			for i, exit := range []*exitStatus{clean, failed, killed} {
+				if got := shouldRestart(tc.policy, exit); got != tc.want[i] {
+					t.Errorf("unexpected result for %s: %v, want: %v", exit, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestServiceRestart(t *testing.T) {
+	unit := &Unit{
+		Name:         "crasher",
+		Kind:         "app",
+		Command:      "sh",
+		Arguments:    []string{"-c", "exit 1"},
+		Restart:      RestartOnFailure,
+		RestartDelay: Duration(50 * time.Millisecond),
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && svc.GetState().Restarts < 3 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := svc.Stop(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	restarts := svc.GetState().Restarts
+	if restarts < 3 {
+		t.Fatalf("unexpected number of restarts: %d", restarts)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if got := svc.GetState().Restarts; got != restarts {
+		t.Errorf("service restarted after stop: %d restarts, want %d", got, restarts)
+	}
+}
+
+func TestStartLimiter(t *testing.T) {
+	l := &startLimiter{burst: 3, interval: 10 * time.Second}
+	now := time.Now()
+	for i, tc := range []struct {
+		offset    time.Duration
+		shouldErr bool
+	}{
+		{offset: 0},
+		{offset: time.Second},
+		{offset: 2 * time.Second},
+		{offset: 3 * time.Second, shouldErr: true},
+		{offset: 10500 * time.Millisecond},
+		{offset: 10800 * time.Millisecond, shouldErr: true},
+	} {
+		err := l.allow(now.Add(tc.offset))
+		if (err != nil) != tc.shouldErr {
+			t.Fatalf("unexpected result for restart %d: %v, want error: %v", i, err, tc.shouldErr)
+		}
+	}
+}
+
+func TestServiceCrashLoop(t *testing.T) {
+	unit := &Unit{
+		Name:               "crasher",
+		Kind:               "app",
+		Command:            "sh",
+		Arguments:          []string{"-c", "exit 1"},
+		Restart:            RestartAlways,
+		RestartDelay:       Duration(10 * time.Millisecond),
+		StartLimitBurst:    2,
+		StartLimitInterval: Duration(time.Minute),
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	defer svc.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if st := svc.GetStatus(); st.Error != nil && strings.Contains(st.Error.Error(), "crash loop") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	status := svc.GetStatus()
+	if status.Current != FailureStatus || status.Error == nil || !strings.Contains(status.Error.Error(), "crash loop") {
+		t.Fatalf("unexpected status: %v, %v", status.Current, status.Error)
+	}
+	if got := svc.GetState().Restarts; got != 2 {
+		t.Errorf("unexpected number of restarts: %d, want: 2", got)
+	}
+}
+
+func TestServiceRestartReadiness(t *testing.T) {
+	dir := t.TempDir()
+	unit := &Unit{
+		Name:          "webapp",
+		Kind:          "app",
+		Command:       "sh",
+		Arguments:     []string{"-c", "if [ -e started ]; then exec sleep 60; fi; touch started ready; sleep 0.2; rm ready; exit 1"},
+		WorkDirectory: dir,
+		ReadyProbe: &Probe{
+			Kind:      ExecProbe,
+			Target:    "test",
+			Arguments: []string{"-f", filepath.Join(dir, "ready")},
+			Interval:  Duration(50 * time.Millisecond),
+		},
+		ReadyTimeout:       Duration(500 * time.Millisecond),
+		Restart:            RestartAlways,
+		RestartDelay:       Duration(10 * time.Millisecond),
+		StartLimitBurst:    2,
+		StartLimitInterval: Duration(time.Minute),
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	defer svc.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && svc.GetState().Restarts < 1 {
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if state := svc.GetState(); state.Current != PendingState {
+		t.Errorf("unexpected state of restarted app prior to becoming ready: %v", state.Current)
+	}
+
+	// The restarted app never becomes ready, and the readiness failures
+	// count toward the start limit.
+	for time.Now().Before(deadline) {
+		if st := svc.GetStatus(); st.Error != nil && strings.Contains(st.Error.Error(), "crash loop") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if status := svc.GetStatus(); status.Current != FailureStatus || status.Error == nil || !strings.Contains(status.Error.Error(), "crash loop") {
+		t.Fatalf("unexpected status: %v, %v", status.Current, status.Error)
+	}
+	if got := svc.GetState().Restarts; got != 2 {
+		t.Errorf("unexpected number of restarts: %d, want: 2", got)
+	}
+}
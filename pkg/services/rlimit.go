@@ -0,0 +1,142 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RlimitInfinity is the value of an unlimited resource limit.
+const RlimitInfinity = math.MaxUint64
+
+// Rlimit is a resource limit of the commands of a unit.
+type Rlimit struct {
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+// launchRlimit is a resource limit applied by the launcher.
+type launchRlimit struct {
+	Resource int    `json:"resource"`
+	Soft     uint64 `json:"soft"`
+	Hard     uint64 `json:"hard"`
+}
+
+// The resources accepting the values with K, M, G, and T size suffixes.
+var rlimitSizeResources = map[string]bool{
+	"as":    true,
+	"core":  true,
+	"stack": true,
+}
+
+// ParseRlimit parses the value of the limit of the resource, e.g. nofile.
+// The value is either a single limit, applied as both soft and hard
+// limits, or soft and hard limits separated by colon, e.g. 1024:65536. A
+// limit is a number or infinity. The limits of as, core, and stack
+// resources may have K, M, G, or T size suffix.
+func ParseRlimit(resource, s string) (*Rlimit, error) {
+	if !rlimitSupported {
+		return nil, fmt.Errorf("resource limits are not supported on this platform")
+	}
+	if _, exists := rlimitResources[resource]; !exists {
+		return nil, fmt.Errorf("unsupported %q resource limit", resource)
+	}
+	soft, hard, found := strings.Cut(s, ":")
+	if !found {
+		hard = soft
+	}
+	limit := &Rlimit{}
+	var err error
+	if limit.Soft, err = parseRlimitValue(resource, soft); err != nil {
+		return nil, err
+	}
+	if limit.Hard, err = parseRlimitValue(resource, hard); err != nil {
+		return nil, err
+	}
+	if limit.Soft > limit.Hard {
+		return nil, fmt.Errorf("soft %q resource limit exceeds hard limit", resource)
+	}
+	return limit, nil
+}
+
+func parseRlimitValue(resource, s string) (uint64, error) {
+	if s == "infinity" {
+		return RlimitInfinity, nil
+	}
+	var n uint64
+	var err error
+	if rlimitSizeResources[resource] {
+		n, err = ParseByteSize(s)
+	} else {
+		n, err = strconv.ParseUint(s, 10, 64)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid %q resource limit value: %q", resource, s)
+	}
+	return n, nil
+}
+
+// validateLimits checks the resource limits of the unit. It returns an
+// error when the current process lacks the privileges to raise a hard
+// limit.
+func (u *Unit) validateLimits() error {
+	if len(u.Limits) > 0 && !rlimitSupported {
+		return fmt.Errorf("resource limits are not supported on this platform")
+	}
+	for resource, limit := range u.Limits {
+		r, exists := rlimitResources[resource]
+		if !exists {
+			return fmt.Errorf("unsupported %q resource limit", resource)
+		}
+		if limit == nil || limit.Soft > limit.Hard {
+			return fmt.Errorf("invalid %q resource limit", resource)
+		}
+		if canRaiseRlimit() {
+			continue
+		}
+		current, err := getRlimit(r)
+		if err != nil {
+			return fmt.Errorf("failed getting %q resource limit: %w", resource, err)
+		}
+		if limit.Hard > current.Hard {
+			return fmt.Errorf("insufficient privileges to raise hard %q resource limit", resource)
+		}
+	}
+	return nil
+}
+
+// rlimits returns the resource limits of the unit in the order of their
+// names.
+func (u *Unit) rlimits() []launchRlimit {
+	names := make([]string, 0, len(u.Limits))
+	for name := range u.Limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var limits []launchRlimit
+	for _, name := range names {
+		limit := u.Limits[name]
+		limits = append(limits, launchRlimit{
+			Resource: rlimitResources[name],
+			Soft:     limit.Soft,
+			Hard:     limit.Hard,
+		})
+	}
+	return limits
+}
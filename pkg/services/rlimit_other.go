@@ -0,0 +1,39 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin
+
+package services
+
+import "fmt"
+
+// Resource limits are supported on Linux and macOS only. The other
+// platforms differ in the available resources and in the types of the
+// limits.
+const rlimitSupported = false
+
+// rlimitResources are the supported resource limits.
+var rlimitResources = map[string]int{}
+
+func getRlimit(resource int) (*Rlimit, error) {
+	return nil, fmt.Errorf("resource limits are not supported on this platform")
+}
+
+func setRlimit(limit launchRlimit) error {
+	return fmt.Errorf("resource limits are not supported on this platform")
+}
+
+func canRaiseRlimit() bool {
+	return false
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+)
+
+func TestParseRlimit(t *testing.T) {
+	testcases := []struct {
+		name      string
+		resource  string
+		value     string
+		want      *Rlimit
+		shouldErr bool
+		err       string
+	}{
+		{
+			name:     "test single limit",
+			resource: "nofile",
+			value:    "65536",
+			want:     &Rlimit{Soft: 65536, Hard: 65536},
+		},
+		{
+			name:     "test soft and hard limits",
+			resource: "nproc",
+			value:    "1024:infinity",
+			want:     &Rlimit{Soft: 1024, Hard: RlimitInfinity},
+		},
+		{
+			name:     "test size limit",
+			resource: "as",
+			value:    "512M:1G",
+			want:     &Rlimit{Soft: 512 << 20, Hard: 1 << 30},
+		},
+		{
+			name:     "test disabled core dumps",
+			resource: "core",
+			value:    "0",
+			want:     &Rlimit{},
+		},
+		{
+			name:      "test size suffix of non-size limit",
+			resource:  "cpu",
+			value:     "10K",
+			shouldErr: true,
+			err:       `invalid "cpu" resource limit value: "10K"`,
+		},
+		{
+			name:      "test soft limit exceeding hard limit",
+			resource:  "nofile",
+			value:     "4096:1024",
+			shouldErr: true,
+			err:       `soft "nofile" resource limit exceeds hard limit`,
+		},
+		{
+			name:      "test unsupported resource",
+			resource:  "rss",
+			value:     "1024",
+			shouldErr: true,
+			err:       `unsupported "rss" resource limit`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseRlimit(tc.resource, tc.value)
+			if err != nil {
+				if !tc.shouldErr {
+					t.Fatalf("expected success, got: %v", err)
+				}
+				if err.Error() != tc.err {
+					t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+				}
+				return
+			}
+			if tc.shouldErr {
+				t.Fatalf("unexpected success, want: %v", tc.err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ParseRlimit() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	unit := &Unit{
+		Name:    "webapp",
+		Kind:    "app",
+		Command: "sleep",
+		Limits:  map[string]*Rlimit{"nofile": {Soft: 1024, Hard: 512}},
+	}
+	if !rlimitSupported {
+		if err := unit.validate(); err == nil || !strings.Contains(err.Error(), "resource limits are not supported on this platform") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err := unit.validate(); err == nil || !strings.Contains(err.Error(), `invalid "nofile" resource limit`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if os.Geteuid() == 0 {
+		return
+	}
+	unit.Limits = map[string]*Rlimit{"nofile": {Soft: 1024, Hard: RlimitInfinity}}
+	if err := unit.validate(); err == nil || !strings.Contains(err.Error(), `insufficient privileges to raise hard "nofile" resource limit`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServiceLimits(t *testing.T) {
+	dir := t.TempDir()
+	script := `echo "$(ulimit -Sn) $(ulimit -Hn) $(ulimit -c)" > `
+	unit := &Unit{
+		Name:      "webapp",
+		Kind:      "app",
+		Command:   "sh",
+		Arguments: []string{"-c", script + "app.txt; exec sleep 60"},
+		ExecStartPre: []*Exec{
+			{Command: "sh", Arguments: []string{"-c", script + "hook.txt"}},
+		},
+		WorkDirectory: dir,
+		Limits: map[string]*Rlimit{
+			"nofile": {Soft: 256, Hard: 512},
+			"core":   {},
+		},
+		ReadyProbe: &Probe{
+			Kind:      ExecProbe,
+			Target:    "test",
+			Arguments: []string{"-s", filepath.Join(dir, "app.txt")},
+			Interval:  Duration(20 * time.Millisecond),
+		},
+	}
+	if err := unit.validate(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	defer svc.Stop()
+
+	for _, name := range []string{"hook.txt", "app.txt"} {
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := strings.TrimSpace(string(b)), "256 512 0"; got != want {
+			t.Errorf("unexpected limits in %s: %q, want: %q", name, got, want)
+		}
+	}
+}
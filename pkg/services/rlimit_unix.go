@@ -0,0 +1,53 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+
+package services
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const rlimitSupported = true
+
+// rlimitResources are the supported resource limits, by name.
+var rlimitResources = map[string]int{
+	"as":     unix.RLIMIT_AS,
+	"core":   unix.RLIMIT_CORE,
+	"cpu":    unix.RLIMIT_CPU,
+	"nofile": unix.RLIMIT_NOFILE,
+	"nproc":  unix.RLIMIT_NPROC,
+	"stack":  unix.RLIMIT_STACK,
+}
+
+func getRlimit(resource int) (*Rlimit, error) {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(resource, &rlim); err != nil {
+		return nil, err
+	}
+	return &Rlimit{Soft: uint64(rlim.Cur), Hard: uint64(rlim.Max)}, nil
+}
+
+func setRlimit(limit launchRlimit) error {
+	return unix.Setrlimit(limit.Resource, &unix.Rlimit{Cur: limit.Soft, Max: limit.Hard})
+}
+
+// canRaiseRlimit returns true when the current process is privileged to
+// raise hard resource limits.
+func canRaiseRlimit() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The kinds of the mounts of the filesystem sandbox.
+const (
+	readOnlyMount     = "ro"
+	readWriteMount    = "rw"
+	inaccessibleMount = "inaccessible"
+	tmpfsMount        = "tmpfs"
+)
+
+// The directories protect_system makes read-only.
+var protectedSystemPaths = []string{"/usr", "/etc"}
+
+// The directories private_tmp replaces.
+var privateTmpPaths = []string{"/tmp", "/var/tmp"}
+
+// launchMount is a mount the launcher applies in the mount namespace of the
+// command.
+type launchMount struct {
+	Path string `json:"path"`
+	Kind string `json:"kind"`
+}
+
+// sandboxEnabled returns true when the commands of the unit run in a
+// restricted view of the filesystem.
+func (u *Unit) sandboxEnabled() bool {
+	return u.PrivateTmp || u.ProtectSystem || len(u.ReadOnlyPaths) > 0 ||
+		len(u.ReadWritePaths) > 0 || len(u.InaccessiblePaths) > 0
+}
+
+// namespaced returns true when the commands of the unit run in their own
+// mount or network namespace.
+func (u *Unit) namespaced() bool {
+	return u.sandboxEnabled() || u.PrivateNetwork
+}
+
+// validateSandbox checks the filesystem sandbox settings of the unit.
+func (u *Unit) validateSandbox() error {
+	if u.PrivateNetwork {
+		if !sandboxSupported {
+			return fmt.Errorf("private network is not supported on this platform")
+		}
+		if !canSandbox() {
+			return fmt.Errorf("insufficient privileges to create network namespace")
+		}
+	}
+	if !u.sandboxEnabled() {
+		return nil
+	}
+	if !sandboxSupported {
+		return fmt.Errorf("filesystem sandboxing is not supported on this platform")
+	}
+	paths := map[string][]string{
+		"read_only_paths":    u.ReadOnlyPaths,
+		"read_write_paths":   u.ReadWritePaths,
+		"inaccessible_paths": u.InaccessiblePaths,
+	}
+	for _, k := range []string{"read_only_paths", "read_write_paths", "inaccessible_paths"} {
+		for _, p := range paths[k] {
+			if !filepath.IsAbs(p) || filepath.Clean(p) == "/" {
+				return fmt.Errorf("%s: invalid %q path", k, p)
+			}
+		}
+	}
+	if !canSandbox() {
+		return fmt.Errorf("insufficient privileges to sandbox filesystem")
+	}
+	return nil
+}
+
+// mounts returns the mounts of the filesystem sandbox of the unit. The
+// mounts of the nested paths follow the mounts of their parents, and
+// override them.
+func (u *Unit) mounts() []launchMount {
+	var mounts []launchMount
+	add := func(kind string, paths ...string) {
+		for _, p := range paths {
+			mounts = append(mounts, launchMount{Path: filepath.Clean(p), Kind: kind})
+		}
+	}
+	if u.ProtectSystem {
+		add(readOnlyMount, protectedSystemPaths...)
+	}
+	if u.PrivateTmp {
+		add(tmpfsMount, privateTmpPaths...)
+	}
+	add(readOnlyMount, u.ReadOnlyPaths...)
+	add(readWriteMount, u.ReadWritePaths...)
+	add(inaccessibleMount, u.InaccessiblePaths...)
+	sort.SliceStable(mounts, func(a, b int) bool {
+		return strings.Count(mounts[a].Path, "/") < strings.Count(mounts[b].Path, "/")
+	})
+	return mounts
+}
+
+// validateSandboxPaths checks that the paths of the filesystem sandbox of
+// the unit exist.
+func validateSandboxPaths(u *Unit) error {
+	for _, m := range u.mounts() {
+		if m.Kind == tmpfsMount {
+			continue
+		}
+		if _, err := os.Stat(m.Path); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("sandbox path does not exist: %s", m.Path)
+			}
+			return fmt.Errorf("sandbox path erred: %s", m.Path)
+		}
+	}
+	for _, p := range u.landlockPaths() {
+		if _, err := os.Stat(p); err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("sandbox path does not exist: %s", p)
+			}
+			return fmt.Errorf("sandbox path erred: %s", p)
+		}
+	}
+	return nil
+}
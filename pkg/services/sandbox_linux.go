@@ -0,0 +1,203 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const sandboxSupported = true
+
+// canSandbox returns true when the current process is privileged to
+// create mount namespaces.
+func canSandbox() bool {
+	return os.Geteuid() == 0 || hasCapabilities(capSysAdmin)
+}
+
+// setMountNamespace makes the command start in a new mount namespace.
+func setMountNamespace(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS
+}
+
+// setNetworkNamespace makes the command start in a new network namespace.
+func setNetworkNamespace(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+}
+
+// joinNamespaces moves the current thread to the mount and network
+// namespaces of the process with the provided PID.
+func joinNamespaces(pid int) error {
+	// The thread sharing the filesystem attributes with the other threads
+	// cannot change its mount namespace.
+	if err := unix.Unshare(unix.CLONE_FS); err != nil {
+		return fmt.Errorf("failed unsharing filesystem attributes: %w", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	for _, ns := range []struct {
+		name string
+		flag int
+	}{
+		{"mnt", unix.CLONE_NEWNS},
+		{"net", unix.CLONE_NEWNET},
+	} {
+		if err := joinNamespace(pid, ns.name, ns.flag); err != nil {
+			return fmt.Errorf("failed joining %s namespace of process %d: %w", ns.name, pid, err)
+		}
+	}
+	// The working directory refers to the previous mount namespace.
+	return os.Chdir(wd)
+}
+
+func joinNamespace(pid int, name string, flag int) error {
+	target, err := os.Open(fmt.Sprintf("/proc/%d/ns/%s", pid, name))
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	targetInfo, err := target.Stat()
+	if err != nil {
+		return err
+	}
+	currentInfo, err := os.Stat("/proc/thread-self/ns/" + name)
+	if err != nil {
+		return err
+	}
+	if os.SameFile(targetInfo, currentInfo) {
+		return nil
+	}
+	return unix.Setns(int(target.Fd()), flag)
+}
+
+// setLoopbackUp brings up the loopback interface in the network namespace
+// of the current process.
+func setLoopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("failed getting loopback interface flags: %w", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("failed bringing up loopback interface: %w", err)
+	}
+	return nil
+}
+
+// applyMounts applies the mounts in the mount namespace of the current
+// process. The kept directories remain accessible at their paths
+// regardless of the mounts.
+func applyMounts(mounts []launchMount, keep []string) error {
+	// The mounts must not propagate to the mount namespace of the parent.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed making mounts private: %w", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	var kept []*os.File
+	for _, p := range keep {
+		f, err := os.OpenFile(p, unix.O_PATH|unix.O_DIRECTORY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		kept = append(kept, f)
+	}
+	for _, m := range mounts {
+		if err := applyMount(m); err != nil {
+			return fmt.Errorf("failed mounting %s: %w", m.Path, err)
+		}
+	}
+	for _, f := range kept {
+		if err := os.MkdirAll(f.Name(), 0700); err != nil {
+			return err
+		}
+		src := fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+		if err := unix.Mount(src, f.Name(), "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed mounting %s: %w", f.Name(), err)
+		}
+	}
+	// The working directory may reside beneath the mounts.
+	return os.Chdir(wd)
+}
+
+func applyMount(m launchMount) error {
+	fi, err := os.Stat(m.Path)
+	if err != nil {
+		if os.IsNotExist(err) && m.Kind == tmpfsMount {
+			return nil
+		}
+		return err
+	}
+	switch m.Kind {
+	case tmpfsMount:
+		return unix.Mount("tmpfs", m.Path, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+	case readOnlyMount, readWriteMount:
+		if err := unix.Mount(m.Path, m.Path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return err
+		}
+		return setReadOnly(m.Path, m.Kind == readOnlyMount)
+	case inaccessibleMount:
+		if fi.IsDir() {
+			return unix.Mount("tmpfs", m.Path, "tmpfs", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=000")
+		}
+		if err := unix.Mount("/dev/null", m.Path, "", unix.MS_BIND, ""); err != nil {
+			return err
+		}
+		return setReadOnly(m.Path, true)
+	}
+	return fmt.Errorf("unsupported %q mount", m.Kind)
+}
+
+// setReadOnly makes the mount at the path and the mounts beneath it
+// read-only, or writable. The kernels prior to 5.12 lack mount_setattr,
+// and the change covers only the mount at the path.
+func setReadOnly(path string, readOnly bool) error {
+	attr := &unix.MountAttr{}
+	flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT)
+	if readOnly {
+		attr.Attr_set = unix.MOUNT_ATTR_RDONLY
+		flags |= unix.MS_RDONLY
+	} else {
+		attr.Attr_clr = unix.MOUNT_ATTR_RDONLY
+	}
+	err := unix.MountSetattr(unix.AT_FDCWD, path, unix.AT_RECURSIVE, attr)
+	if err != unix.ENOSYS {
+		return err
+	}
+	return unix.Mount("", path, "", flags, "")
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+
+package services
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// Filesystem sandboxing is not supported on the platforms other than
+// Linux.
+const sandboxSupported = false
+
+func canSandbox() bool {
+	return false
+}
+
+func setMountNamespace(cmd *exec.Cmd) {}
+
+func setNetworkNamespace(cmd *exec.Cmd) {}
+
+func joinNamespaces(pid int) error {
+	return fmt.Errorf("filesystem sandboxing is not supported on this platform")
+}
+
+func setLoopbackUp() error {
+	return fmt.Errorf("private network is not supported on this platform")
+}
+
+func applyMounts(mounts []launchMount, keep []string) error {
+	return fmt.Errorf("filesystem sandboxing is not supported on this platform")
+}
@@ -0,0 +1,269 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+)
+
+func TestUnitMounts(t *testing.T) {
+	unit := &Unit{
+		PrivateTmp:        true,
+		ProtectSystem:     true,
+		ReadOnlyPaths:     []string{"/srv/webapp/"},
+		ReadWritePaths:    []string{"/etc/webapp", "/srv/webapp/data"},
+		InaccessiblePaths: []string{"/var/lib/caddy"},
+	}
+	want := []launchMount{
+		{Path: "/usr", Kind: readOnlyMount},
+		{Path: "/etc", Kind: readOnlyMount},
+		{Path: "/tmp", Kind: tmpfsMount},
+		{Path: "/var/tmp", Kind: tmpfsMount},
+		{Path: "/srv/webapp", Kind: readOnlyMount},
+		{Path: "/etc/webapp", Kind: readWriteMount},
+		{Path: "/srv/webapp/data", Kind: readWriteMount},
+		{Path: "/var/lib/caddy", Kind: inaccessibleMount},
+	}
+	if diff := cmp.Diff(want, unit.mounts()); diff != "" {
+		t.Errorf("mounts() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestValidateSandbox(t *testing.T) {
+	unit := &Unit{
+		Name:          "webapp",
+		Kind:          "app",
+		Command:       "webapp",
+		ReadOnlyPaths: []string{"srv/webapp"},
+	}
+	err := unit.validate()
+	if sandboxSupported {
+		if err == nil || !strings.Contains(err.Error(), `read_only_paths: invalid "srv/webapp" path`) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	} else if err == nil || !strings.Contains(err.Error(), "filesystem sandboxing is not supported") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestServiceSandbox(t *testing.T) {
+	if !sandboxSupported || os.Geteuid() != 0 {
+		t.Skip("requires mount namespaces and root privileges")
+	}
+	dir := t.TempDir()
+	for _, p := range []string{"ro/rw", "secret"} {
+		if err := os.MkdirAll(filepath.Join(dir, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "secret", "key.pem"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	script := `
+touch ro/file && echo ro writable
+touch ro/rw/file && echo rw writable
+cat secret/key.pem && echo secret readable
+`
+	unit := &Unit{
+		Name:              "webapp",
+		Kind:              "command",
+		Command:           "sh",
+		Arguments:         []string{"-c", script},
+		WorkDirectory:     dir,
+		ReadOnlyPaths:     []string{filepath.Join(dir, "ro")},
+		ReadWritePaths:    []string{filepath.Join(dir, "ro", "rw")},
+		InaccessiblePaths: []string{filepath.Join(dir, "secret")},
+		StdOutFilePath:    filepath.Join(dir, "stdout.log"),
+		StdErrFilePath:    filepath.Join(dir, "stderr.log"),
+	}
+	if err := unit.validate(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Start()
+
+	b, err := os.ReadFile(unit.StdOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(b)), "rw writable"; got != want {
+		t.Errorf("unexpected output: %q, want: %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ro", "rw", "file")); err != nil {
+		t.Errorf("expected file in writable path: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ro", "file")); !os.IsNotExist(err) {
+		t.Errorf("unexpected file in read-only path: %v", err)
+	}
+}
+
+func TestServicePrivateTmp(t *testing.T) {
+	if !sandboxSupported || os.Geteuid() != 0 {
+		t.Skip("requires mount namespaces and root privileges")
+	}
+	fp := filepath.Join(t.TempDir(), "caddy.txt")
+	if err := os.WriteFile(fp, []byte("caddy"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// The app becomes ready only when the file of Caddy in the temporary
+	// directory is not visible, and the notify socket is.
+	unit := &Unit{
+		Name:        "webapp",
+		Kind:        "app",
+		ServiceType: NotifyServiceType,
+		Command:     "sh",
+		Arguments:   []string{"-c", "test -e " + fp + " || exec python3 -c \"$0\" READY=1", notifyScript},
+		PrivateTmp:  true,
+		StopSignal:  "SIGTERM",
+	}
+	if err := unit.validate(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Start()
+	defer svc.Stop()
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+}
+
+func TestServicePrivateTmpHooks(t *testing.T) {
+	if !sandboxSupported || os.Geteuid() != 0 {
+		t.Skip("requires mount namespaces and root privileges")
+	}
+	// The app writes the file to its private temporary directory. The
+	// post-start, reload, and stop commands see the file, while the
+	// post-stop command does not.
+	fp := filepath.Join("/tmp", "appd-hooks-token")
+	dir := t.TempDir()
+	unit := &Unit{
+		Name:        "webapp",
+		Kind:        "app",
+		ServiceType: NotifyServiceType,
+		Command:     "sh",
+		Arguments:   []string{"-c", "echo token > " + fp + " && exec python3 -c \"$0\" READY=1", notifyScript},
+		PrivateTmp:  true,
+		StopSignal:  "SIGTERM",
+		ExecStartPost: []*Exec{
+			{Command: "sh", Arguments: []string{"-c", "echo post-start $(cat " + fp + ")"}},
+		},
+		ExecReload: &Exec{
+			Command:   "sh",
+			Arguments: []string{"-c", "echo reload $(cat " + fp + ")"},
+		},
+		ExecStop: &Exec{
+			Command:   "sh",
+			Arguments: []string{"-c", "echo stop $(cat " + fp + ") && kill $MAINPID"},
+		},
+		ExecStopPost: []*Exec{
+			{Command: "sh", Arguments: []string{"-c", "test -e " + fp + " && echo post-stop token || echo post-stop"}},
+		},
+		StdOutFilePath: filepath.Join(dir, "stdout.log"),
+	}
+	if err := unit.validate(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Start()
+	defer svc.Stop()
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if err := svc.Reload(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if err := svc.Stop(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	b, err := os.ReadFile(unit.StdOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "post-start token\nreload token\nstop token\npost-stop\n"
+	if diff := cmp.Diff(want, string(b)); diff != "" {
+		t.Errorf("unexpected output (-want +got):\n%s", diff)
+	}
+}
+
+func TestServicePrivateNetwork(t *testing.T) {
+	if !sandboxSupported || os.Geteuid() != 0 {
+		t.Skip("requires network namespaces and root privileges")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// The command reaches its own loopback interface, and does not reach
+	// the listener of Caddy.
+	script := `
+import socket, sys
+s = socket.socket()
+s.bind(("127.0.0.1", 0))
+s.listen()
+socket.create_connection(s.getsockname(), timeout=1).close()
+print("loopback reachable")
+try:
+    socket.create_connection(("127.0.0.1", int(sys.argv[1])), timeout=1).close()
+    print("host reachable")
+except OSError:
+    print("host unreachable")
+`
+	dir := t.TempDir()
+	unit := &Unit{
+		Name:           "batch",
+		Kind:           "command",
+		Command:        "python3",
+		Arguments:      []string{"-c", script, strconv.Itoa(l.Addr().(*net.TCPAddr).Port)},
+		PrivateNetwork: true,
+		StdOutFilePath: filepath.Join(dir, "stdout.log"),
+	}
+	if err := unit.validate(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Start(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	b, err := os.ReadFile(unit.StdOutFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(b)), "loopback reachable\nhost unreachable"; got != want {
+		t.Errorf("unexpected output: %q, want: %q", got, want)
+	}
+}
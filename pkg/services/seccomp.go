@@ -0,0 +1,249 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// syscallGroups are the groups of the system calls the syscall filter
+// accepts, by name.
+var syscallGroups = map[string][]string{
+	"@clock": {
+		"adjtimex", "clock_adjtime", "clock_settime", "settimeofday", "stime",
+	},
+	"@cpu-emulation": {
+		"modify_ldt", "subpage_prot", "switch_endian", "vm86", "vm86old",
+	},
+	"@debug": {
+		"lookup_dcookie", "perf_event_open", "pidfd_getfd", "process_vm_readv",
+		"process_vm_writev", "ptrace", "rtas", "s390_runtime_instr",
+		"sys_debug_setcontext",
+	},
+	"@module": {
+		"delete_module", "finit_module", "init_module",
+	},
+	"@mount": {
+		"chroot", "fsconfig", "fsmount", "fsopen", "fspick", "mount",
+		"mount_setattr", "move_mount", "open_tree", "pivot_root", "umount",
+		"umount2",
+	},
+	"@obsolete": {
+		"_sysctl", "afs_syscall", "bdflush", "break", "create_module", "ftime",
+		"get_kernel_syms", "getpmsg", "gtty", "idle", "lock", "mpx", "prof",
+		"profil", "putpmsg", "query_module", "security", "sgetmask",
+		"ssetmask", "stty", "sysfs", "tuxcall", "ulimit", "uselib", "ustat",
+		"vserver",
+	},
+	"@raw-io": {
+		"ioperm", "iopl", "pciconfig_iobase", "pciconfig_read",
+		"pciconfig_write", "s390_pci_mmio_read", "s390_pci_mmio_write",
+	},
+	"@reboot": {
+		"kexec_file_load", "kexec_load", "reboot",
+	},
+	"@swap": {
+		"swapoff", "swapon",
+	},
+}
+
+// systemServiceSyscalls are the system calls of @system-service group,
+// i.e. the ones a typical service needs. The group follows the group of
+// systemd with the same name, except for the keyring, namespace, and
+// personality system calls.
+var systemServiceSyscalls = []string{
+	// Asynchronous I/O.
+	"io_cancel", "io_destroy", "io_getevents", "io_pgetevents", "io_setup",
+	"io_submit", "io_uring_enter", "io_uring_register", "io_uring_setup",
+	// Basic I/O.
+	"close", "close_range", "dup", "dup2", "dup3", "lseek", "pread64",
+	"preadv", "preadv2", "pwrite64", "pwritev", "pwritev2", "read", "readv",
+	"write", "writev",
+	// File ownership.
+	"chown", "fchown", "fchownat", "lchown",
+	// Process basics.
+	"brk", "clock_getres", "clock_gettime", "clock_nanosleep", "execve",
+	"exit", "exit_group", "futex", "futex_waitv", "get_robust_list",
+	"get_thread_area", "getegid", "geteuid", "getgid", "getgroups",
+	"getpgid", "getpgrp", "getpid", "getppid", "getrandom", "getresgid",
+	"getresuid", "getrlimit", "getsid", "gettid", "gettimeofday", "getuid",
+	"membarrier", "mmap", "mprotect", "munmap", "nanosleep", "pause",
+	"prlimit64", "restart_syscall", "rseq", "rt_sigreturn",
+	"sched_getaffinity", "sched_yield", "set_robust_list", "set_thread_area",
+	"set_tid_address", "time", "uname",
+	// Filesystem.
+	"access", "chdir", "chmod", "creat", "faccessat", "faccessat2",
+	"fallocate", "fchdir", "fchmod", "fchmodat", "fchmodat2", "fcntl",
+	"fgetxattr", "flistxattr", "fremovexattr", "fsetxattr", "fstat",
+	"fstatat", "fstatfs", "ftruncate", "futimesat", "getcwd", "getdents",
+	"getdents64", "getxattr", "inotify_add_watch", "inotify_init",
+	"inotify_init1", "inotify_rm_watch", "lgetxattr", "link", "linkat",
+	"listxattr", "llistxattr", "lremovexattr", "lsetxattr", "lstat", "mkdir",
+	"mkdirat", "mknod", "mknodat", "newfstatat", "open", "openat", "openat2",
+	"readlink", "readlinkat", "removexattr", "rename", "renameat",
+	"renameat2", "rmdir", "setxattr", "stat", "statfs", "statx", "symlink",
+	"symlinkat", "truncate", "unlink", "unlinkat", "utime", "utimensat",
+	"utimes",
+	// Event loops.
+	"epoll_create", "epoll_create1", "epoll_ctl", "epoll_pwait",
+	"epoll_pwait2", "epoll_wait", "eventfd", "eventfd2", "poll", "ppoll",
+	"pselect6", "select",
+	// Inter-process communication.
+	"memfd_create", "mq_getsetattr", "mq_notify", "mq_open",
+	"mq_timedreceive", "mq_timedsend", "mq_unlink", "msgctl", "msgget",
+	"msgrcv", "msgsnd", "pipe", "pipe2", "semctl", "semget", "semop",
+	"semtimedop", "shmat", "shmctl", "shmdt", "shmget",
+	// Memory locking.
+	"mlock", "mlock2", "mlockall", "munlock", "munlockall",
+	// Network I/O.
+	"accept", "accept4", "bind", "connect", "getpeername", "getsockname",
+	"getsockopt", "listen", "recvfrom", "recvmmsg", "recvmsg", "sendmmsg",
+	"sendmsg", "sendto", "setsockopt", "shutdown", "socket", "socketpair",
+	// Process management.
+	"capget", "clone", "clone3", "execveat", "fork", "getrusage", "kill",
+	"pidfd_open", "pidfd_send_signal", "prctl", "rt_sigqueueinfo",
+	"rt_tgsigqueueinfo", "tgkill", "times", "tkill", "vfork", "wait4",
+	"waitid",
+	// Resource controls.
+	"ioprio_set", "mbind", "migrate_pages", "move_pages",
+	"sched_setaffinity", "sched_setattr", "sched_setparam",
+	"sched_setscheduler", "set_mempolicy", "set_mempolicy_home_node",
+	"setpriority", "setrlimit",
+	// Memory protection keys.
+	"pkey_alloc", "pkey_free", "pkey_mprotect",
+	// Self-sandboxing, which only restricts the process further.
+	"landlock_add_rule", "landlock_create_ruleset", "landlock_restrict_self",
+	"seccomp",
+	// Credentials.
+	"setgid", "setgroups", "setregid", "setresgid", "setresuid", "setreuid",
+	"setuid",
+	// Signals.
+	"rt_sigaction", "rt_sigpending", "rt_sigprocmask", "rt_sigsuspend",
+	"rt_sigtimedwait", "sigaltstack", "signalfd", "signalfd4",
+	// Synchronization.
+	"fdatasync", "fsync", "msync", "sync", "sync_file_range", "syncfs",
+	// Timers.
+	"alarm", "getitimer", "setitimer", "timer_create", "timer_delete",
+	"timer_getoverrun", "timer_gettime", "timer_settime", "timerfd_create",
+	"timerfd_gettime", "timerfd_settime",
+	// Miscellaneous.
+	"arch_prctl", "capset", "copy_file_range", "fadvise64", "flock",
+	"get_mempolicy", "getcpu", "getpriority", "ioctl", "ioprio_get", "kcmp",
+	"madvise", "mremap", "name_to_handle_at", "readahead",
+	"remap_file_pages", "sched_get_priority_max", "sched_get_priority_min",
+	"sched_getattr", "sched_getparam", "sched_getscheduler",
+	"sched_rr_get_interval", "sendfile", "setfsgid", "setfsuid", "setpgid",
+	"setsid", "splice", "sysinfo", "tee", "umask", "userfaultfd", "vmsplice",
+}
+
+// defaultSyscalls are the system calls the allow list of the syscall
+// filter always includes. The launcher and the runtimes of the commands
+// require them.
+var defaultSyscalls = []string{
+	"arch_prctl", "brk", "clock_gettime", "clock_nanosleep", "execve",
+	"exit", "exit_group", "futex", "getpid", "gettid", "madvise", "mmap",
+	"mprotect", "munmap", "nanosleep", "rt_sigprocmask", "rt_sigreturn",
+	"sched_yield", "sigaltstack", "tgkill",
+}
+
+// launchSyscallFilter is the syscall filter the launcher installs prior to
+// executing the command.
+type launchSyscallFilter struct {
+	// If set to true, the system calls other than the allowed ones fail.
+	AllowList bool `json:"allow_list,omitempty"`
+	// The numbers of the allowed system calls.
+	Allowed []int `json:"allowed,omitempty"`
+	// The numbers of the denied system calls.
+	Denied []int `json:"denied,omitempty"`
+}
+
+// expandSyscalls returns the names of the system calls of the entry of the
+// syscall filter, i.e. a system call or a group.
+func expandSyscalls(entry string) ([]string, error) {
+	if entry == "@system-service" {
+		return systemServiceSyscalls, nil
+	}
+	if strings.HasPrefix(entry, "@") {
+		names, exists := syscallGroups[entry]
+		if !exists {
+			return nil, fmt.Errorf("unsupported %q system call group", entry)
+		}
+		return names, nil
+	}
+	if _, exists := syscallNumbers[entry]; !exists {
+		return nil, fmt.Errorf("unsupported %q system call", entry)
+	}
+	return []string{entry}, nil
+}
+
+// validateSyscallFilter checks the entries of the syscall filter of the
+// unit.
+func (u *Unit) validateSyscallFilter() error {
+	if len(u.SyscallFilter) == 0 {
+		return nil
+	}
+	if !seccompSupported {
+		return fmt.Errorf("syscall filter is not supported on this platform")
+	}
+	for _, entry := range u.SyscallFilter {
+		if _, err := expandSyscalls(strings.TrimPrefix(entry, "~")); err != nil {
+			return fmt.Errorf("syscall filter: %w", err)
+		}
+	}
+	return nil
+}
+
+// syscallFilter returns the syscall filter of the unit. The entries
+// prefixed with tilde are denied. When any entry is allowed, the filter
+// is an allow list, and the system calls other than the allowed ones fail.
+func (u *Unit) syscallFilter() *launchSyscallFilter {
+	if len(u.SyscallFilter) == 0 {
+		return nil
+	}
+	allowed := make(map[string]bool)
+	denied := make(map[string]bool)
+	for _, entry := range u.SyscallFilter {
+		name, deny := strings.CutPrefix(entry, "~")
+		names, _ := expandSyscalls(name)
+		for _, name := range names {
+			if deny {
+				denied[name] = true
+			} else {
+				allowed[name] = true
+			}
+		}
+	}
+	filter := &launchSyscallFilter{AllowList: len(allowed) > 0}
+	if filter.AllowList {
+		for _, name := range defaultSyscalls {
+			allowed[name] = true
+		}
+	}
+	for name, nr := range syscallNumbers {
+		switch {
+		case denied[name]:
+			filter.Denied = append(filter.Denied, nr)
+		case allowed[name]:
+			filter.Allowed = append(filter.Allowed, nr)
+		case filter.AllowList:
+			filter.Denied = append(filter.Denied, nr)
+		}
+	}
+	sort.Ints(filter.Allowed)
+	sort.Ints(filter.Denied)
+	return filter
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The syscall filter is supported on the architectures with the system
+// call numbers.
+const seccompSupported = seccompArch != 0
+
+// The return values of the seccomp filters.
+const (
+	seccompRetKillProcess = 0x80000000
+	seccompRetErrno       = 0x00050000
+	seccompRetAllow       = 0x7fff0000
+)
+
+// The offsets of the fields of seccomp_data structure.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+)
+
+// syscallX32Bit is the bit of the numbers of the system calls of x32 ABI.
+const syscallX32Bit = 0x40000000
+
+// compile returns the BPF program of the syscall filter. In allow list
+// mode, the allowed system calls succeed, the known others fail with
+// EPERM, and the unknown ones, e.g. the ones newer than the table, fail
+// with ENOSYS, which the libraries treat as missing system calls.
+func (f *launchSyscallFilter) compile() []unix.SockFilter {
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+	}
+	eperm := uint32(seccompRetErrno | unix.EPERM)
+	prog := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, seccompArch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNr),
+		jump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, syscallX32Bit, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, eperm),
+	}
+	add := func(numbers []int, ret uint32) {
+		for _, nr := range numbers {
+			prog = append(prog,
+				jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(nr), 0, 1),
+				stmt(unix.BPF_RET|unix.BPF_K, ret),
+			)
+		}
+	}
+	if f.AllowList {
+		add(f.Allowed, seccompRetAllow)
+		add(f.Denied, eperm)
+		return append(prog, stmt(unix.BPF_RET|unix.BPF_K, seccompRetErrno|uint32(unix.ENOSYS)))
+	}
+	add(f.Denied, eperm)
+	return append(prog, stmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow))
+}
+
+// installSyscallFilter installs the syscall filter in the thread executing
+// the command. Without CAP_SYS_ADMIN capability, the kernel requires
+// no_new_privs.
+func installSyscallFilter(f *launchSyscallFilter) error {
+	prog := f.compile()
+	fprog := &unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	_, _, errno := unix.RawSyscall(unix.SYS_PRCTL, unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(fprog)))
+	if errno == unix.EACCES {
+		if err := setNoNewPrivileges(); err != nil {
+			return err
+		}
+		_, _, errno = unix.RawSyscall(unix.SYS_PRCTL, unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(fprog)))
+	}
+	runtime.KeepAlive(prog)
+	if errno != 0 {
+		return fmt.Errorf("failed installing syscall filter: %w", errno)
+	}
+	return nil
+}
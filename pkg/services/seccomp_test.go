@@ -0,0 +1,212 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestUnitSyscallFilter(t *testing.T) {
+	if !seccompSupported {
+		t.Skip("requires syscall filter")
+	}
+	filter := (&Unit{SyscallFilter: []string{"~@mount", "~reboot"}}).syscallFilter()
+	if filter.AllowList || len(filter.Allowed) != 0 {
+		t.Fatalf("unexpected allow list: %+v", filter)
+	}
+	for _, name := range []string{"mount", "umount2", "pivot_root", "reboot"} {
+		if !slices.Contains(filter.Denied, syscallNumbers[name]) {
+			t.Errorf("expected denied %s system call", name)
+		}
+	}
+
+	filter = (&Unit{SyscallFilter: []string{"@system-service", "~mkdirat"}}).syscallFilter()
+	if !filter.AllowList {
+		t.Fatalf("expected allow list: %+v", filter)
+	}
+	for _, name := range []string{"read", "write", "execve", "clone", "mmap"} {
+		if !slices.Contains(filter.Allowed, syscallNumbers[name]) {
+			t.Errorf("expected allowed %s system call", name)
+		}
+	}
+	// The privileged, namespace, and keyring system calls are not in the
+	// group.
+	for _, name := range []string{
+		"mkdirat", "mount", "reboot", "init_module", "ptrace", "bpf", "setns",
+		"unshare", "keyctl", "add_key", "request_key", "personality", "acct",
+		"sethostname", "syslog", "open_by_handle_at", "fanotify_init",
+	} {
+		if !slices.Contains(filter.Denied, syscallNumbers[name]) {
+			t.Errorf("expected denied %s system call", name)
+		}
+	}
+}
+
+func TestValidateSyscallFilter(t *testing.T) {
+	testcases := []struct {
+		name   string
+		filter []string
+		err    string
+	}{
+		{
+			name:   "test unsupported group",
+			filter: []string{"@system-service", "~@network"},
+			err:    `unit "webapp": syscall filter: unsupported "@network" system call group`,
+		},
+		{
+			name:   "test unsupported system call",
+			filter: []string{"~fly"},
+			err:    `unit "webapp": syscall filter: unsupported "fly" system call`,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			unit := &Unit{Name: "webapp", Kind: "app", Command: "webapp", SyscallFilter: tc.filter}
+			want := tc.err
+			if !seccompSupported {
+				want = `unit "webapp": syscall filter is not supported on this platform`
+			}
+			if err := unit.validate(); err == nil || err.Error() != want {
+				t.Fatalf("unexpected error: %v, want: %v", err, want)
+			}
+		})
+	}
+}
+
+func TestServiceSyscallFilter(t *testing.T) {
+	if !seccompSupported {
+		t.Skip("requires syscall filter")
+	}
+	testcases := []struct {
+		name   string
+		filter []string
+		want   string
+	}{
+		{
+			name:   "test allowed system calls",
+			filter: []string{"@system-service"},
+			want:   "created",
+		},
+		{
+			name:   "test allow list with denied system calls",
+			filter: []string{"@system-service", "~mkdir", "~mkdirat"},
+			want:   "denied",
+		},
+		{
+			name:   "test deny list",
+			filter: []string{"~@mount", "~mkdir", "~mkdirat"},
+			want:   "denied",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			unit := &Unit{
+				Name:           "webapp",
+				Kind:           "command",
+				Command:        "sh",
+				Arguments:      []string{"-c", "if mkdir data 2>/dev/null; then echo created; else echo denied; fi"},
+				WorkDirectory:  dir,
+				SyscallFilter:  tc.filter,
+				StdOutFilePath: filepath.Join(dir, "stdout.log"),
+			}
+			if err := unit.validate(); err != nil {
+				t.Fatal(err)
+			}
+			svc, err := NewService(0, unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.Start(); err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			b, err := os.ReadFile(unit.StdOutFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(b)); got != tc.want {
+				t.Errorf("unexpected output: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServiceSystemServiceFilter(t *testing.T) {
+	if !seccompSupported {
+		t.Skip("requires syscall filter")
+	}
+	// The unshare system call without flags succeeds for any process,
+	// unless the filter denies it.
+	script := `
+import ctypes, os
+libc = ctypes.CDLL(None, use_errno=True)
+if libc.unshare(0) == 0:
+    print("allowed")
+else:
+    print(os.strerror(ctypes.get_errno()))
+`
+	testcases := []struct {
+		name   string
+		filter []string
+		want   string
+	}{
+		{
+			name:   "test namespace system calls outside system service group",
+			filter: []string{"@system-service"},
+			want:   "Operation not permitted",
+		},
+		{
+			name:   "test namespace system calls explicitly allowed",
+			filter: []string{"@system-service", "unshare"},
+			want:   "allowed",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			unit := &Unit{
+				Name:           "webapp",
+				Kind:           "command",
+				Command:        "python3",
+				Arguments:      []string{"-c", script},
+				SyscallFilter:  tc.filter,
+				StdOutFilePath: filepath.Join(dir, "stdout.log"),
+			}
+			if err := unit.validate(); err != nil {
+				t.Fatal(err)
+			}
+			svc, err := NewService(0, unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.Start(); err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			b, err := os.ReadFile(unit.StdOutFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.TrimSpace(string(b)); got != tc.want {
+				t.Errorf("unexpected output: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -15,13 +15,18 @@
 package services
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
 
 // Service represents an application instance.
 type Service struct {
+	mu     sync.Mutex
 	Seq    int        `json:"seq,omitempty"`
 	Unit   *Unit      `json:"unit,omitempty"`
 	Status *Status    `json:"status,omitempty"`
@@ -29,6 +34,22 @@ type Service struct {
 	Kind   WorkerKind `json:"kind,omitempty"`
 	logger *zap.Logger
 	worker *worker
+	// The function called when the application exits unexpectedly and
+	// does not restart.
+	onExit func(*Service)
+	// Set to true when the service is being stopped.
+	stopping bool
+	// Set to true while the service waits for the app to become ready.
+	starting bool
+	// The time the application process started.
+	startedAt time.Time
+	// The number of consecutive restarts of the application.
+	restarts     int
+	restartTimer *time.Timer
+	startLimiter *startLimiter
+	// The listen sockets of the application. They remain open across
+	// the restarts of the application until the service stops.
+	listeners []*socketListener
 }
 
 // NewService creates Service instance.
@@ -56,6 +77,9 @@ func NewService(seq int, unit *Unit, logger *zap.Logger) (*Service, error) {
 
 // Start starts Service instance.
 func (svc *Service) Start() error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
 	svc.logger.Debug("starting service",
 		zap.String("service_name", svc.Unit.Name),
 		zap.String("kind", svc.Unit.Kind),
@@ -64,7 +88,13 @@ func (svc *Service) Start() error {
 
 	switch svc.Kind {
 	case WorkerKind(CommandWorker):
-		err := newAdhocWorker(svc.Unit.Command, svc.Unit.Arguments, svc.Unit.StdOutFilePath, svc.Unit.StdErrFilePath)
+		err := svc.runHooks("exec_start_pre", svc.Unit.ExecStartPre, nil)
+		if err == nil {
+			err = newAdhocWorker(context.Background(), svc.Unit, nil, svc.Unit.Command, svc.Unit.Arguments, nil)
+		}
+		if err == nil {
+			err = svc.runHooks("exec_start_post", svc.Unit.ExecStartPost, nil)
+		}
 		if err != nil {
 			svc.State.Current = CompletedState
 			svc.Status.Current = FailureStatus
@@ -77,18 +107,28 @@ func (svc *Service) Start() error {
 			svc.Status.Error = nil
 		}
 	case WorkerKind(ApplicationWorker):
-		w, err := newWorker(uint(svc.Unit.Seq), svc.Unit.Command, svc.Unit.Arguments, svc.Unit.StdOutFilePath, svc.Unit.StdErrFilePath, svc.logger)
-		if err != nil {
+		svc.stopping = false
+		svc.restarts = 0
+		svc.Status.ProbeOutput = ""
+		svc.startLimiter = &startLimiter{
+			burst:    svc.Unit.startLimitBurst(),
+			interval: svc.Unit.startLimitInterval(),
+		}
+		if err := svc.runHooks("exec_start_pre", svc.Unit.ExecStartPre, nil); err != nil {
 			svc.State.Current = CompletedState
 			svc.Status.Current = FailureStatus
 			svc.Status.Error = err
 			return err
-		} else {
-			svc.worker = w
-			svc.State.Current = CompletedState
-			svc.State.Error = nil
-			svc.Status.Current = SuccessStatus
-			svc.Status.Error = nil
+		}
+		if err := svc.startApp(); err != nil {
+			svc.closeListeners()
+			return err
+		}
+		if err := svc.completeStart(svc.worker); err != nil {
+			// Prevent restarts of the service that failed to start.
+			svc.stopping = true
+			svc.closeListeners()
+			return err
 		}
 	default:
 		svc.State.Current = CompletedState
@@ -100,8 +140,300 @@ func (svc *Service) Start() error {
 	return nil
 }
 
+// startApp starts the application process. The caller must hold svc.mu.
+func (svc *Service) startApp() error {
+	var err error
+	if svc.listeners == nil && len(svc.Unit.ListenSockets) > 0 {
+		svc.listeners, err = listenSockets(svc.Unit.ListenSockets)
+		if err != nil {
+			svc.State.Current = CompletedState
+			svc.Status.Current = FailureStatus
+			svc.Status.Error = err
+			return err
+		}
+	}
+	w, err := newWorker(uint(svc.Unit.Seq), svc.Unit, svc.listeners, svc.handleExit, svc.handleNotify, svc.logger)
+	if err != nil {
+		svc.State.Current = CompletedState
+		svc.Status.Current = FailureStatus
+		svc.Status.Error = err
+		return err
+	}
+	svc.worker = w
+	svc.startedAt = time.Now()
+	svc.State.Current = RunningState
+	svc.State.Error = nil
+	svc.State.Pid = w.Pid
+	svc.State.MainPid = 0
+	svc.Status.Current = SuccessStatus
+	svc.Status.Error = nil
+	svc.Status.Message = ""
+	svc.Status.Errno = 0
+	return nil
+}
+
+// completeStart waits for the app to become ready, runs the post-start
+// hooks, and starts monitoring the app. On failure, it stops the app. The
+// caller must hold svc.mu.
+func (svc *Service) completeStart(w *worker) error {
+	// Release the lock while waiting for the app to become ready, so
+	// that the app is able to report its status.
+	svc.starting = true
+	svc.State.Current = PendingState
+	svc.mu.Unlock()
+	err := svc.waitReady(w)
+	if err == nil {
+		err = svc.runHooks("exec_start_post", svc.Unit.ExecStartPost, w)
+	}
+	svc.mu.Lock()
+	svc.starting = false
+	if err == nil && w.exitStatus() != nil {
+		err = fmt.Errorf("process exited prior to completing start: %s", w.exitStatus())
+	}
+	if err != nil {
+		svc.logger.Debug("service failed to start",
+			zap.String("service_name", svc.Unit.Name),
+			zap.String("kind", svc.Unit.Kind),
+			zap.Int("seq_id", svc.Seq),
+			zap.Error(err),
+		)
+		w.stop()
+		svc.worker = nil
+		svc.State.Current = CompletedState
+		svc.State.Pid = 0
+		svc.Status.Current = FailureStatus
+		svc.Status.Error = err
+		if hookErr := svc.runHooks("exec_stop_post", svc.Unit.ExecStopPost, nil); hookErr != nil {
+			svc.logger.Warn("failed running service hook",
+				zap.String("service_name", svc.Unit.Name),
+				zap.String("kind", svc.Unit.Kind),
+				zap.Int("seq_id", svc.Seq),
+				zap.Error(hookErr),
+			)
+		}
+		return err
+	}
+	svc.State.Current = RunningState
+	svc.monitorHealth(w)
+	svc.monitorWatchdog(w)
+	return nil
+}
+
+// runHooks runs the lifecycle hook commands one after another. It stops at
+// the first failed command. When the app is provided, the commands run in
+// its namespaces.
+func (svc *Service) runHooks(kind string, hooks []*Exec, w *worker) error {
+	for _, e := range hooks {
+		svc.logger.Debug("running service hook",
+			zap.String("service_name", svc.Unit.Name),
+			zap.String("kind", svc.Unit.Kind),
+			zap.Int("seq_id", svc.Seq),
+			zap.String("hook", kind),
+			zap.String("cmd", e.Command),
+		)
+		if err := newAdhocWorker(context.Background(), svc.Unit, w, e.Command, e.Arguments, nil); err != nil {
+			return fmt.Errorf("%s command %q failed: %w", kind, e.Command, err)
+		}
+	}
+	return nil
+}
+
+// waitReady waits for the application to become ready. It returns an
+// error when the process exits or the readiness timeout expires.
+func (svc *Service) waitReady(w *worker) error {
+	p := svc.Unit.ReadyProbe
+	notify := svc.Unit.ServiceType == NotifyServiceType
+	if p == nil && !notify {
+		return nil
+	}
+
+	timeout := svc.Unit.readyTimeout()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	if notify {
+		select {
+		case <-w.notifier.ready:
+		case <-w.done:
+			return fmt.Errorf("process exited prior to becoming ready: %s", w.exitStatus())
+		case <-deadline.C:
+			return fmt.Errorf("app did not send READY=1 within %s", timeout)
+		}
+	}
+
+	for p != nil {
+		_, err := p.check(w)
+		if err == nil {
+			break
+		}
+		select {
+		case <-w.done:
+			return fmt.Errorf("process exited prior to becoming ready: %s", w.exitStatus())
+		case <-deadline.C:
+			return fmt.Errorf("readiness probe failed within %s: %w", timeout, err)
+		case <-time.After(p.interval()):
+		}
+	}
+
+	svc.logger.Debug("service is ready",
+		zap.String("service_name", svc.Unit.Name),
+		zap.String("kind", svc.Unit.Kind),
+		zap.Int("seq_id", svc.Seq),
+	)
+	return nil
+}
+
+// handleNotify records the status reported by the application via
+// sd_notify protocol.
+func (svc *Service) handleNotify(msg *notifyMessage) {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	if msg.Status != "" {
+		svc.Status.Message = msg.Status
+	}
+	if msg.MainPid > 0 {
+		svc.State.MainPid = msg.MainPid
+	}
+	if msg.Errno > 0 {
+		svc.Status.Errno = msg.Errno
+	}
+	if msg.Stopping {
+		svc.State.Current = StoppingState
+	}
+
+	svc.logger.Debug("service sent notification",
+		zap.String("service_name", svc.Unit.Name),
+		zap.String("kind", svc.Unit.Kind),
+		zap.Int("seq_id", svc.Seq),
+		zap.Bool("ready", msg.Ready),
+		zap.Bool("stopping", msg.Stopping),
+		zap.String("status", msg.Status),
+		zap.Int("main_pid", msg.MainPid),
+		zap.Int("errno", msg.Errno),
+	)
+}
+
+// monitorHealth starts periodic health checks of the application. When
+// the number of consecutive failed checks reaches the failure threshold,
+// the application is terminated and handled per its restart policy.
+func (svc *Service) monitorHealth(w *worker) {
+	p := svc.Unit.HealthProbe
+	if p == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.interval())
+		defer ticker.Stop()
+		var failures int
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+			}
+			output, err := p.check(w)
+			if err == nil {
+				failures = 0
+				continue
+			}
+			failures++
+			svc.logger.Debug("service failed health check",
+				zap.String("service_name", svc.Unit.Name),
+				zap.String("kind", svc.Unit.Kind),
+				zap.Int("seq_id", svc.Seq),
+				zap.Int("failures", failures),
+				zap.String("probe_output", output),
+				zap.Error(err),
+			)
+			if failures < p.failureThreshold() {
+				continue
+			}
+
+			svc.mu.Lock()
+			if svc.worker != w {
+				svc.mu.Unlock()
+				return
+			}
+			svc.Status.ProbeOutput = output
+			svc.mu.Unlock()
+
+			svc.logger.Warn("service is unhealthy",
+				zap.String("service_name", svc.Unit.Name),
+				zap.String("kind", svc.Unit.Kind),
+				zap.Int("seq_id", svc.Seq),
+				zap.Int("failures", failures),
+				zap.String("probe_output", output),
+				zap.Error(err),
+			)
+			w.kill(fmt.Sprintf("health check failed %d times: %v", failures, err), nil)
+			return
+		}
+	}()
+}
+
+// monitorWatchdog terminates the application when it does not send
+// WATCHDOG=1 message within the watchdog interval, or when it sends
+// WATCHDOG=trigger message. The hung application is handled per its
+// restart policy.
+func (svc *Service) monitorWatchdog(w *worker) {
+	interval := time.Duration(svc.Unit.WatchdogInterval)
+	if interval <= 0 || w.notifier == nil {
+		return
+	}
+	go func() {
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
+		reason := fmt.Sprintf("watchdog timeout after %s", interval)
+	loop:
+		for {
+			select {
+			case <-w.done:
+				return
+			case msg := <-w.notifier.watchdog:
+				if msg == "trigger" {
+					reason = "watchdog triggered by app"
+					break loop
+				}
+				if !timer.Stop() {
+					<-timer.C
+				}
+				timer.Reset(interval)
+			case <-timer.C:
+				break loop
+			}
+		}
+
+		svc.mu.Lock()
+		if svc.worker != w {
+			svc.mu.Unlock()
+			return
+		}
+		svc.mu.Unlock()
+
+		svc.logger.Warn("service watchdog expired",
+			zap.String("service_name", svc.Unit.Name),
+			zap.String("kind", svc.Unit.Kind),
+			zap.Int("seq_id", svc.Seq),
+			zap.Int("pid", w.Pid),
+			zap.String("reason", reason),
+		)
+		var diag os.Signal
+		if svc.Unit.WatchdogSignal != "" {
+			sig, err := parseSignal(svc.Unit.WatchdogSignal)
+			if err == nil {
+				diag = sig
+			}
+		}
+		w.kill(reason, diag)
+	}()
+}
+
 // Stop stops Service instance.
 func (svc *Service) Stop() error {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
 	switch svc.Kind {
 	case WorkerKind(CommandWorker):
 		svc.logger.Debug("skipped stopping service",
@@ -112,16 +444,42 @@ func (svc *Service) Stop() error {
 		)
 		return nil
 	case WorkerKind(ApplicationWorker):
+		svc.stopping = true
+		if svc.restartTimer != nil {
+			svc.restartTimer.Stop()
+			svc.restartTimer = nil
+		}
+		// The sockets close once the app stops.
+		defer svc.closeListeners()
+		if svc.worker == nil {
+			svc.logger.Debug("skipped stopping service",
+				zap.String("service_name", svc.Unit.Name),
+				zap.String("kind", svc.Unit.Kind),
+				zap.String("reason", "not running"),
+				zap.Int("seq_id", svc.Seq),
+			)
+			return nil
+		}
 		svc.logger.Debug("stopping service",
 			zap.String("service_name", svc.Unit.Name),
 			zap.String("kind", svc.Unit.Kind),
 			zap.Int("seq_id", svc.Seq),
 		)
-		workerState, workerStatus := svc.worker.stop()
+		w := svc.worker
+		// The worker calls handleExit when the process exits.
+		svc.mu.Unlock()
+		workerState, workerStatus := w.stop()
+		hookErr := svc.runHooks("exec_stop_post", svc.Unit.ExecStopPost, nil)
+		svc.mu.Lock()
+		svc.worker = nil
 		svc.State.Current = workerState.Current
 		svc.State.Error = workerState.Error
 		svc.Status.Current = workerStatus.Current
 		svc.Status.Error = workerStatus.Error
+		if svc.Status.Error == nil && hookErr != nil {
+			svc.Status.Current = FailureStatus
+			svc.Status.Error = hookErr
+		}
 		if svc.Status.Error != nil {
 			svc.logger.Debug("failed stopping service",
 				zap.String("service_name", svc.Unit.Name),
@@ -147,3 +505,232 @@ func (svc *Service) Stop() error {
 	svc.Status.Current = SuccessStatus
 	return nil
 }
+
+// closeListeners closes the listen sockets of the application and removes
+// their files. The caller must hold svc.mu.
+func (svc *Service) closeListeners() {
+	closeListeners(svc.listeners)
+	svc.listeners = nil
+}
+
+// Reload reloads the configuration of the application by running its
+// reload command, or by sending SIGHUP signal to it.
+func (svc *Service) Reload() error {
+	svc.mu.Lock()
+	if svc.Kind != WorkerKind(ApplicationWorker) {
+		svc.mu.Unlock()
+		return fmt.Errorf("reload is not supported for %q type", svc.Unit.Kind)
+	}
+	w := svc.worker
+	svc.mu.Unlock()
+	if w == nil {
+		return fmt.Errorf("service is not running")
+	}
+
+	svc.logger.Debug("reloading service",
+		zap.String("service_name", svc.Unit.Name),
+		zap.String("kind", svc.Unit.Kind),
+		zap.Int("seq_id", svc.Seq),
+	)
+	if err := w.reload(); err != nil {
+		svc.logger.Warn("failed reloading service",
+			zap.String("service_name", svc.Unit.Name),
+			zap.String("kind", svc.Unit.Kind),
+			zap.Int("seq_id", svc.Seq),
+			zap.Error(err),
+		)
+		return fmt.Errorf("failed reloading service: %w", err)
+	}
+	return nil
+}
+
+// GetStatus returns a copy of the last recorded status of Service.
+func (svc *Service) GetStatus() *Status {
+	svc.mu.Lock()
+	st := *svc.Status
+	var cg *cgroup
+	if svc.worker != nil {
+		cg = svc.worker.cgroup
+	}
+	svc.mu.Unlock()
+	if cg != nil {
+		st.Usage, _ = readUsage(cg.path)
+	}
+	return &st
+}
+
+// GetState returns a copy of the last recorded state of Service.
+func (svc *Service) GetState() *State {
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+	st := *svc.State
+	return &st
+}
+
+// handleExit records the exit of the application process. The expected
+// argument is true when the exit is the result of stopping the service.
+func (svc *Service) handleExit(exit *exitStatus, expected bool) {
+	svc.mu.Lock()
+	svc.State.Pid = 0
+	svc.State.ExitCode = exit.Code
+	svc.State.ExitSignal = exit.Signal
+	svc.State.ExitedAt = &exit.Time
+	if expected || svc.worker == nil || svc.starting {
+		// The exit is the result of stopping the service, or the service
+		// has already handled the exit, or the start of the service
+		// handles it.
+		svc.mu.Unlock()
+		return
+	}
+
+	svc.State.Current = StoppedState
+	switch {
+	case exit.clean():
+		svc.Status.Current = SuccessStatus
+		svc.Status.Error = nil
+	case exit.Reason != "":
+		svc.Status.Current = FailureStatus
+		svc.Status.Error = fmt.Errorf("process terminated: %s", exit.Reason)
+	default:
+		svc.Status.Current = FailureStatus
+		svc.Status.Error = fmt.Errorf("process exited unexpectedly: %s", exit)
+	}
+
+	svc.logger.Warn("service exited unexpectedly",
+		zap.String("service_name", svc.Unit.Name),
+		zap.String("kind", svc.Unit.Kind),
+		zap.Int("seq_id", svc.Seq),
+		zap.Int("exit_code", exit.Code),
+		zap.String("exit_signal", exit.Signal),
+	)
+
+	if len(svc.Unit.ExecStopPost) > 0 {
+		// The exit is handled. Stopping the service while the hooks run
+		// prevents the restart.
+		svc.worker = nil
+		svc.mu.Unlock()
+		if err := svc.runHooks("exec_stop_post", svc.Unit.ExecStopPost, nil); err != nil {
+			svc.logger.Warn("failed running service hook",
+				zap.String("service_name", svc.Unit.Name),
+				zap.String("kind", svc.Unit.Kind),
+				zap.Int("seq_id", svc.Seq),
+				zap.Error(err),
+			)
+		}
+		svc.mu.Lock()
+	}
+
+	if !svc.stopping && shouldRestart(svc.Unit.Restart, exit) && svc.tryRestart() {
+		svc.mu.Unlock()
+		return
+	}
+
+	onExit := svc.onExit
+	svc.mu.Unlock()
+
+	if onExit != nil {
+		onExit(svc)
+	}
+}
+
+// tryRestart schedules the restart of the application unless the
+// application is in a crash loop. The caller must hold svc.mu.
+func (svc *Service) tryRestart() bool {
+	svc.worker = nil
+	if err := svc.startLimiter.allow(time.Now()); err != nil {
+		svc.State.Current = StoppedState
+		svc.Status.Current = FailureStatus
+		svc.Status.Error = err
+		svc.logger.Error("service crash loop detected",
+			zap.String("service_name", svc.Unit.Name),
+			zap.String("kind", svc.Unit.Kind),
+			zap.Int("seq_id", svc.Seq),
+			zap.Int("restarts", svc.State.Restarts),
+			zap.Int("start_limit_burst", svc.startLimiter.burst),
+			zap.Duration("start_limit_interval", svc.startLimiter.interval),
+		)
+		return false
+	}
+	svc.scheduleRestart()
+	return true
+}
+
+// scheduleRestart schedules the restart of the application with
+// exponential backoff. The caller must hold svc.mu.
+func (svc *Service) scheduleRestart() {
+	if time.Since(svc.startedAt) >= svc.Unit.restartMaxDelay() {
+		// The application ran long enough to reset the backoff.
+		svc.restarts = 0
+	}
+	delay := restartDelay(svc.Unit.restartDelay(), svc.Unit.restartMaxDelay(), svc.restarts)
+	svc.restarts++
+
+	svc.logger.Info("scheduled service restart",
+		zap.String("service_name", svc.Unit.Name),
+		zap.String("kind", svc.Unit.Kind),
+		zap.Int("seq_id", svc.Seq),
+		zap.Duration("delay", delay),
+		zap.Int("restarts", svc.restarts),
+	)
+
+	svc.restartTimer = time.AfterFunc(delay, svc.restart)
+}
+
+// restart restarts the application.
+func (svc *Service) restart() {
+	svc.mu.Lock()
+	svc.restartTimer = nil
+	if svc.stopping {
+		svc.mu.Unlock()
+		return
+	}
+
+	svc.logger.Info("restarting service",
+		zap.String("service_name", svc.Unit.Name),
+		zap.String("kind", svc.Unit.Kind),
+		zap.Int("seq_id", svc.Seq),
+		zap.Int("restarts", svc.restarts),
+	)
+
+	svc.State.Restarts++
+	svc.mu.Unlock()
+	err := svc.runHooks("exec_start_pre", svc.Unit.ExecStartPre, nil)
+	svc.mu.Lock()
+	if svc.stopping {
+		svc.mu.Unlock()
+		return
+	}
+	if err != nil {
+		svc.State.Current = StoppedState
+		svc.Status.Current = FailureStatus
+		svc.Status.Error = err
+	} else {
+		err = svc.startApp()
+	}
+	if err == nil {
+		// The restarted app passes the same readiness gate as the first
+		// start. Its failure counts toward the start limit.
+		err = svc.completeStart(svc.worker)
+		if err == nil || svc.stopping {
+			svc.mu.Unlock()
+			return
+		}
+	}
+
+	svc.logger.Error("failed restarting service",
+		zap.String("service_name", svc.Unit.Name),
+		zap.String("kind", svc.Unit.Kind),
+		zap.Int("seq_id", svc.Seq),
+		zap.Error(err),
+	)
+	if svc.tryRestart() {
+		svc.mu.Unlock()
+		return
+	}
+	onExit := svc.onExit
+	svc.mu.Unlock()
+
+	if onExit != nil {
+		onExit(svc)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor of the sockets passed to an
+// app per the socket activation protocol.
+const listenFdsStart = 3
+
+// validateListenSockets checks the unix sockets appd listens on behalf of
+// the unit.
+func (u *Unit) validateListenSockets() error {
+	if len(u.ListenSockets) == 0 {
+		return nil
+	}
+	if u.Kind != "app" {
+		return fmt.Errorf("listen sockets are not supported for %q type", u.Kind)
+	}
+	if !launcherSupported {
+		return fmt.Errorf("listen sockets are not supported on this platform")
+	}
+	seen := make(map[string]bool)
+	for _, p := range u.ListenSockets {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("listen socket path is not absolute: %s", p)
+		}
+		if seen[filepath.Clean(p)] {
+			return fmt.Errorf("duplicate listen socket path: %s", p)
+		}
+		seen[filepath.Clean(p)] = true
+	}
+	return nil
+}
+
+// socketListener is a unix socket appd listens on behalf of an app. The
+// socket outlives the processes of the app, so that it accepts the
+// connections while the app restarts.
+type socketListener struct {
+	path string
+	file *os.File
+	// The socket file at the path, which differs once another listener
+	// replaces it, e.g. on a config reload.
+	info os.FileInfo
+}
+
+// listenSockets creates the unix sockets at the paths. A stale socket at
+// a path gets replaced.
+func listenSockets(paths []string) ([]*socketListener, error) {
+	var listeners []*socketListener
+	for _, p := range paths {
+		l, err := listenSocket(p)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed listening on %s: %w", p, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func listenSocket(p string) (*socketListener, error) {
+	if fi, err := os.Lstat(p); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("file exists and is not a socket")
+		}
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: p, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The listener removes the socket file on close, unless the file has
+	// been replaced.
+	l.SetUnlinkOnClose(false)
+	defer l.Close()
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	f, err := l.File()
+	if err != nil {
+		return nil, err
+	}
+	return &socketListener{path: p, file: f, info: info}, nil
+}
+
+// close closes the socket and removes its file, unless another listener
+// has replaced it.
+func (l *socketListener) close() {
+	l.file.Close()
+	if fi, err := os.Lstat(l.path); err == nil && os.SameFile(fi, l.info) {
+		os.Remove(l.path)
+	}
+}
+
+func closeListeners(listeners []*socketListener) {
+	for _, l := range listeners {
+		l.close()
+	}
+}
+
+// listenEnv returns the environment variables of the socket activation
+// protocol, except LISTEN_PID, which the launcher exports.
+func listenEnv(paths []string) []string {
+	var names []string
+	for _, p := range paths {
+		names = append(names, strings.TrimSuffix(filepath.Base(p), filepath.Ext(p)))
+	}
+	return []string{
+		"LISTEN_FDS=" + strconv.Itoa(len(paths)),
+		"LISTEN_FDNAMES=" + strings.Join(names, ":"),
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// listenScript serves the names of the network interfaces on the socket
+// passed per the socket activation protocol.
+const listenScript = `
+import os, socket
+if os.environ["LISTEN_PID"] != str(os.getpid()) or os.environ["LISTEN_FDS"] != "1":
+    raise SystemExit(1)
+s = socket.socket(fileno=3)
+while True:
+    c, _ = s.accept()
+    names = [name for _, name in socket.if_nameindex()]
+    c.sendall((os.environ["LISTEN_FDNAMES"] + " " + ",".join(names)).encode())
+    c.close()
+`
+
+func TestServiceListenSockets(t *testing.T) {
+	testcases := []struct {
+		name           string
+		privateNetwork bool
+		want           string
+	}{
+		{
+			name: "test app listening on passed socket",
+		},
+		{
+			name:           "test app in private network listening on passed socket",
+			privateNetwork: true,
+			want:           "webapp lo",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.privateNetwork && (!sandboxSupported || os.Geteuid() != 0) {
+				t.Skip("requires network namespaces and root privileges")
+			}
+			if !launcherSupported {
+				t.Skip("requires launcher")
+			}
+			socketPath := filepath.Join(t.TempDir(), "webapp.sock")
+			unit := &Unit{
+				Name:           "webapp",
+				Kind:           "app",
+				Command:        "python3",
+				Arguments:      []string{"-c", listenScript},
+				PrivateNetwork: tc.privateNetwork,
+				ListenSockets:  []string{socketPath},
+				StopSignal:     "SIGTERM",
+			}
+			if err := unit.validate(); err != nil {
+				t.Fatal(err)
+			}
+			svc, err := NewService(0, unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = svc.Start()
+			defer svc.Stop()
+			if err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+
+			// The socket accepts the connections prior to the start of
+			// the app.
+			conn, err := net.DialTimeout("unix", socketPath, time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			b, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := string(b)
+			if tc.want == "" {
+				if len(got) < 7 || got[:7] != "webapp " {
+					t.Errorf("unexpected response: %q", got)
+				}
+				return
+			}
+			if got != tc.want {
+				t.Errorf("unexpected response: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// restartScript serves a single connection on the passed socket and exits.
+const restartScript = `
+import socket
+s = socket.socket(fileno=3)
+c, _ = s.accept()
+c.sendall(b"served")
+c.close()
+`
+
+func TestServiceListenSocketsRestart(t *testing.T) {
+	if !launcherSupported {
+		t.Skip("requires launcher")
+	}
+	socketPath := filepath.Join(t.TempDir(), "webapp.sock")
+	unit := &Unit{
+		Name:          "webapp",
+		Kind:          "app",
+		Command:       "python3",
+		Arguments:     []string{"-c", restartScript},
+		ListenSockets: []string{socketPath},
+		Restart:       RestartAlways,
+		RestartDelay:  Duration(300 * time.Millisecond),
+		StopSignal:    "SIGTERM",
+	}
+	if err := unit.validate(); err != nil {
+		t.Fatal(err)
+	}
+	svc, err := NewService(0, unit, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = svc.Start()
+	defer svc.Stop()
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	// The second connection arrives while the app restarts, and the
+	// restarted app serves it.
+	for i := 0; i < 2; i++ {
+		conn, err := net.DialTimeout("unix", socketPath, time.Second)
+		if err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		b, err := io.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("connection %d: %v", i, err)
+		}
+		if string(b) != "served" {
+			t.Fatalf("connection %d: unexpected response: %q", i, b)
+		}
+	}
+	if st := svc.GetState(); st.Restarts == 0 {
+		t.Fatalf("expected restarted app")
+	}
+
+	if err := svc.Stop(); err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+	if _, err := os.Lstat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("expected removed socket %s, got: %v", socketPath, err)
+	}
+}
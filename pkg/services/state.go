@@ -17,6 +17,7 @@ package services
 import (
 	"encoding/json"
 	"strings"
+	"time"
 )
 
 type StateKind int
@@ -28,6 +29,7 @@ const (
 	StoppedState
 	PausedState
 	CompletedState
+	StoppingState
 )
 
 // State represent the last recorded state of a service.
@@ -35,6 +37,19 @@ type State struct {
 	Current     StateKind `json:"current,omitempty"`
 	ServiceName string    `json:"service_name,omitempty"`
 	Error       error     `json:"error,omitempty"`
+	// The process ID of a running application.
+	Pid int `json:"pid,omitempty"`
+	// The process ID of the main process reported by an application via
+	// sd_notify MAINPID message.
+	MainPid int `json:"main_pid,omitempty"`
+	// The exit code of the last application process.
+	ExitCode int `json:"exit_code,omitempty"`
+	// The signal that terminated the last application process.
+	ExitSignal string `json:"exit_signal,omitempty"`
+	// The time the last application process exited.
+	ExitedAt *time.Time `json:"exited_at,omitempty"`
+	// The number of times the application restarted.
+	Restarts int `json:"restarts,omitempty"`
 }
 
 // NewState creates State instance.
@@ -47,7 +62,7 @@ func NewState(name string, kind StateKind) *State {
 }
 
 func (k StateKind) String() string {
-	return [...]string{"Unknown", "Pending", "Running", "Stopped", "Paused", "Completed"}[k]
+	return [...]string{"Unknown", "Pending", "Running", "Stopped", "Paused", "Completed", "Stopping"}[k]
 }
 
 func (k StateKind) EnumIndex() int {
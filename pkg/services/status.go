@@ -26,6 +26,7 @@ const (
 	PendingStatus
 	FailureStatus
 	SuccessStatus
+	DependencyFailedStatus
 )
 
 // Status represent the last recorded status of a service.
@@ -33,6 +34,15 @@ type Status struct {
 	Current     StatusKind `json:"current,omitempty"`
 	ServiceName string     `json:"service_name,omitempty"`
 	Error       error      `json:"error,omitempty"`
+	// The output of the last failed health probe.
+	ProbeOutput string `json:"probe_output,omitempty"`
+	// The free-form status reported by an app via sd_notify STATUS message.
+	Message string `json:"message,omitempty"`
+	// The errno-style error code reported by an app via sd_notify ERRNO
+	// message.
+	Errno int `json:"errno,omitempty"`
+	// The resource usage of the unit, when it runs in its cgroup.
+	Usage *Usage `json:"usage,omitempty"`
 }
 
 // NewStatus creates Status instance.
@@ -45,7 +55,7 @@ func NewStatus(name string, kind StatusKind) *Status {
 }
 
 func (k StatusKind) String() string {
-	return [...]string{"Unknown", "Pending", "Failure", "Success"}[k]
+	return [...]string{"Unknown", "Pending", "Failure", "Success", "DependencyFailed"}[k]
 }
 
 func (k StatusKind) EnumIndex() int {
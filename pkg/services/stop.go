@@ -0,0 +1,133 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultStopSignal  = "SIGINT"
+	defaultStopTimeout = 4 * time.Second
+	// The time the worker waits for the processes in the process group
+	// to exit after killing them.
+	groupKillTimeout = time.Second
+)
+
+// The supported kill modes.
+const (
+	// KillModeProcess sends the stop signals and the kill only to the
+	// main process of an app.
+	KillModeProcess = "process"
+	// KillModeGroup sends the stop signals and the kill to all the
+	// processes in the process group of an app.
+	KillModeGroup = "group"
+	// KillModeMixed sends the stop signals to the main process of an app
+	// and the kill to all the processes in its process group.
+	KillModeMixed = "mixed"
+)
+
+// StopStep is a step of stopping an app: the signal sent to the app and
+// the time the app has to exit prior to the next step.
+type StopStep struct {
+	Signal  string   `json:"signal,omitempty"`
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// stopStep is a StopStep with the parsed signal.
+type stopStep struct {
+	signal  os.Signal
+	timeout time.Duration
+}
+
+func (u *Unit) stopTimeout() time.Duration {
+	if u.StopTimeout > 0 {
+		return time.Duration(u.StopTimeout)
+	}
+	return defaultStopTimeout
+}
+
+func (u *Unit) killMode() string {
+	if u.KillMode != "" {
+		return u.KillMode
+	}
+	return KillModeGroup
+}
+
+// validateStop checks the kill mode, the stop signal, the stop timeout,
+// and the stop escalation of the unit.
+func (u *Unit) validateStop() error {
+	if u.Kind != "app" && (u.StopSignal != "" || u.StopTimeout != 0 || len(u.StopEscalation) > 0) {
+		return fmt.Errorf("stop settings are not supported for %q type", u.Kind)
+	}
+	if u.Kind != "app" && u.KillMode != "" {
+		return fmt.Errorf("kill mode is not supported for %q type", u.Kind)
+	}
+	switch u.KillMode {
+	case "", KillModeProcess, KillModeGroup, KillModeMixed:
+	default:
+		return fmt.Errorf("invalid kill mode: %q", u.KillMode)
+	}
+	if u.StopTimeout < 0 {
+		return fmt.Errorf("stop timeout is negative")
+	}
+	if u.StopSignal != "" {
+		if _, err := parseSignal(u.StopSignal); err != nil {
+			return fmt.Errorf("stop signal: %w", err)
+		}
+	}
+	for i, step := range u.StopEscalation {
+		sig, err := parseSignal(step.Signal)
+		if err != nil {
+			return fmt.Errorf("stop escalation: %w", err)
+		}
+		if sig == syscall.SIGKILL && i != len(u.StopEscalation)-1 {
+			return fmt.Errorf("stop escalation: %s must be the last step", step.Signal)
+		}
+		if step.Timeout < 0 {
+			return fmt.Errorf("stop escalation: %s timeout is negative", step.Signal)
+		}
+	}
+	return nil
+}
+
+// stopSteps returns the steps of stopping the app. When the app does not
+// exit after the last step, it gets killed.
+func (u *Unit) stopSteps() []stopStep {
+	steps := u.StopEscalation
+	if len(steps) == 0 {
+		steps = []*StopStep{{Signal: u.StopSignal}}
+	}
+	var stopSteps []stopStep
+	for _, step := range steps {
+		name := step.Signal
+		if name == "" {
+			name = defaultStopSignal
+		}
+		sig, err := parseSignal(name)
+		if err != nil {
+			sig, _ = parseSignal(defaultStopSignal)
+		}
+		timeout := time.Duration(step.Timeout)
+		if timeout <= 0 {
+			timeout = u.stopTimeout()
+		}
+		stopSteps = append(stopSteps, stopStep{signal: sig, timeout: timeout})
+	}
+	return stopSteps
+}
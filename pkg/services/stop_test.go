@@ -0,0 +1,237 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+)
+
+func TestStopSteps(t *testing.T) {
+	testcases := []struct {
+		name string
+		unit *Unit
+		want []stopStep
+	}{
+		{
+			name: "test default stop steps",
+			unit: &Unit{Kind: "app"},
+			want: []stopStep{{signal: syscall.SIGINT, timeout: defaultStopTimeout}},
+		},
+		{
+			name: "test stop signal and timeout",
+			unit: &Unit{Kind: "app", StopSignal: "SIGTERM", StopTimeout: Duration(30 * time.Second)},
+			want: []stopStep{{signal: syscall.SIGTERM, timeout: 30 * time.Second}},
+		},
+		{
+			name: "test stop escalation",
+			unit: &Unit{
+				Kind:        "app",
+				StopSignal:  "SIGQUIT",
+				StopTimeout: Duration(time.Minute),
+				StopEscalation: []*StopStep{
+					{Signal: "TERM", Timeout: Duration(10 * time.Second)},
+					{Signal: "INT"},
+					{Signal: "KILL"},
+				},
+			},
+			want: []stopStep{
+				{signal: syscall.SIGTERM, timeout: 10 * time.Second},
+				{signal: syscall.SIGINT, timeout: time.Minute},
+				{signal: syscall.SIGKILL, timeout: time.Minute},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.unit.validateStop(); err != nil {
+				t.Fatal(err)
+			}
+			got := tc.unit.stopSteps()
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(stopStep{})); diff != "" {
+				t.Errorf("stopSteps() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidateStop(t *testing.T) {
+	testcases := []struct {
+		name string
+		unit *Unit
+		err  string
+	}{
+		{
+			name: "test invalid stop signal",
+			unit: &Unit{Kind: "app", StopSignal: "SIGFOO"},
+			err:  "stop signal: unsupported signal",
+		},
+		{
+			name: "test kill in the middle of stop escalation",
+			unit: &Unit{Kind: "app", StopEscalation: []*StopStep{{Signal: "KILL"}, {Signal: "TERM"}}},
+			err:  "KILL must be the last step",
+		},
+		{
+			name: "test stop settings of command",
+			unit: &Unit{Kind: "command", StopSignal: "SIGTERM"},
+			err:  "stop settings are not supported",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.unit.validateStop()
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Fatalf("unexpected error: %v, want: %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestServiceStopEscalation(t *testing.T) {
+	testcases := []struct {
+		name        string
+		script      string
+		minDuration time.Duration
+		maxDuration time.Duration
+		shouldErr   bool
+	}{
+		{
+			name:        "test app exits on second signal",
+			script:      `trap "" TERM; trap "exit 0" INT; while true; do sleep 0.05; done`,
+			minDuration: 300 * time.Millisecond,
+			maxDuration: 2 * time.Second,
+		},
+		{
+			name:        "test app ignoring signals gets killed",
+			script:      `trap "" TERM INT; while true; do sleep 0.05; done`,
+			minDuration: 600 * time.Millisecond,
+			maxDuration: 2 * time.Second,
+			shouldErr:   true,
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			unit := &Unit{
+				Name:      "webapp",
+				Kind:      "app",
+				Command:   "sh",
+				Arguments: []string{"-c", tc.script},
+				StopEscalation: []*StopStep{
+					{Signal: "SIGTERM", Timeout: Duration(300 * time.Millisecond)},
+					{Signal: "SIGINT", Timeout: Duration(300 * time.Millisecond)},
+				},
+			}
+			svc, err := NewService(0, unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.Start(); err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			// Let the shell install the traps.
+			time.Sleep(100 * time.Millisecond)
+
+			stoppedAt := time.Now()
+			err = svc.Stop()
+			elapsed := time.Since(stoppedAt)
+			if tc.shouldErr {
+				if err == nil || err.Error() != "force terminated process" {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			if elapsed < tc.minDuration || elapsed > tc.maxDuration {
+				t.Errorf("stop took %v, want between %v and %v", elapsed, tc.minDuration, tc.maxDuration)
+			}
+		})
+	}
+}
+
+func TestServiceKillMode(t *testing.T) {
+	testcases := []struct {
+		name     string
+		killMode string
+		script   string
+		stop     bool
+		want     bool
+	}{
+		{
+			name:     "test process kill mode leaves descendants",
+			killMode: KillModeProcess,
+			script:   "sleep 60 & wait",
+			stop:     true,
+			want:     true,
+		},
+		{
+			name:     "test group kill mode stops descendants",
+			killMode: KillModeGroup,
+			script:   "sleep 60 & wait",
+			stop:     true,
+		},
+		{
+			name:     "test mixed kill mode kills descendants",
+			killMode: KillModeMixed,
+			script:   "sleep 60 & wait",
+			stop:     true,
+		},
+		{
+			name:     "test descendants of exited app get killed",
+			killMode: KillModeGroup,
+			script:   "sleep 60 & sleep 0.2; exit 1",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			unit := &Unit{
+				Name:       "webapp",
+				Kind:       "app",
+				Command:    "sh",
+				Arguments:  []string{"-c", tc.script},
+				KillMode:   tc.killMode,
+				StopSignal: "SIGTERM",
+			}
+			svc, err := NewService(0, unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := svc.Start(); err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			pgid := svc.GetState().Pid
+			defer signalGroup(pgid, syscall.SIGKILL)
+			time.Sleep(100 * time.Millisecond)
+
+			if tc.stop {
+				if err := svc.Stop(); err != nil {
+					t.Fatalf("expected success, got: %v", err)
+				}
+			} else {
+				deadline := time.Now().Add(5 * time.Second)
+				for time.Now().Before(deadline) && svc.GetState().Current != StoppedState {
+					time.Sleep(20 * time.Millisecond)
+				}
+			}
+			if got := groupAlive(pgid); got != tc.want {
+				t.Errorf("unexpected descendants: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
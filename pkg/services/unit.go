@@ -20,6 +20,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // Unit is a configuration for a command or app.
@@ -32,20 +33,110 @@ type Unit struct {
 	Description string `json:"description,omitempty"`
 	// The type of the Unit: app, command.
 	Kind string `json:"kind,omitempty"`
+	// The startup type of an app: simple or notify. A notify app is
+	// considered started once it sends READY=1 via sd_notify protocol.
+	ServiceType string `json:"type,omitempty"`
 	// The command to execute.
 	Command string `json:"cmd,omitempty"`
 	// The executed command arguments.
 	Arguments []string `json:"args,omitempty"`
 	// The directory the command starts in.
 	WorkDirectory string `json:"workdir,omitempty"`
+	// The environment variables of the command.
+	Environment map[string]string `json:"env,omitempty"`
+	// The paths to the files, in dotenv format, with the environment
+	// variables of the command.
+	EnvFiles []string `json:"env_files,omitempty"`
+	// If set to true, the command does not inherit the environment of
+	// Caddy.
+	ClearEnv bool `json:"clear_env,omitempty"`
+	// The glob patterns of the names of the environment variables the
+	// command inherits from Caddy, in addition to the ones in
+	// Config.EnvPassthrough. When neither is set, the command inherits all
+	// the variables.
+	EnvPassthrough []string `json:"env_passthrough,omitempty"`
+	// The patterns from Config.EnvPassthrough. Config sets it during
+	// validation.
+	GlobalEnvPassthrough []string `json:"-"`
+	// The user the commands run as, by name or ID.
+	User string `json:"user,omitempty"`
+	// The group the commands run as, by name or ID. Defaults to the
+	// primary group of User.
+	Group string `json:"group,omitempty"`
+	// The supplementary groups of the commands, by name or ID. Defaults to
+	// the groups User is a member of.
+	SupplementaryGroups []string `json:"supplementary_groups,omitempty"`
+	// The credential resolved from User, Group, and SupplementaryGroups.
+	// Config sets it during validation.
+	Credential *Credential `json:"-"`
+	// The resource limits of the commands, by resource name: nofile,
+	// nproc, core, as, cpu, or stack.
+	Limits map[string]*Rlimit `json:"limits,omitempty"`
+	// If set to true, the processes of the unit run in the cgroup of the
+	// unit, which accounts their resource usage. The resource controls
+	// below imply it.
+	Cgroup bool `json:"cgroup,omitempty"`
+	// The memory usage limit of the processes, in bytes. When exceeded,
+	// the processes get killed by the OOM killer.
+	MemoryMax uint64 `json:"memory_max,omitempty"`
+	// The memory usage throttling threshold of the processes, in bytes.
+	MemoryHigh uint64 `json:"memory_high,omitempty"`
+	// The CPU bandwidth limit of the processes.
+	CPUMax *CPUMax `json:"cpu_max,omitempty"`
+	// The relative share of CPU time of the processes, from 1 to 10000.
+	CPUWeight uint64 `json:"cpu_weight,omitempty"`
+	// The maximum number of the processes.
+	PidsMax uint64 `json:"pids_max,omitempty"`
+	// The relative share of IO time of the processes, from 1 to 10000.
+	IOWeight uint64 `json:"io_weight,omitempty"`
+	// The path prefix of the cgroups of the processes of the unit. Each
+	// process gets its own cgroup at the path with a sequence number
+	// suffix. Config sets it during validation.
+	CgroupPath string `json:"-"`
+	// If set to true, the commands get private /tmp and /var/tmp
+	// directories.
+	PrivateTmp bool `json:"private_tmp,omitempty"`
+	// If set to true, /usr and /etc directories are read-only for the
+	// commands.
+	ProtectSystem bool `json:"protect_system,omitempty"`
+	// The paths read-only for the commands.
+	ReadOnlyPaths []string `json:"read_only_paths,omitempty"`
+	// The paths writable for the commands beneath the read-only paths.
+	ReadWritePaths []string `json:"read_write_paths,omitempty"`
+	// The paths inaccessible for the commands.
+	InaccessiblePaths []string `json:"inaccessible_paths,omitempty"`
+	// If set to true, the commands run in a new network namespace with the
+	// loopback interface only.
+	PrivateNetwork bool `json:"private_network,omitempty"`
+	// The paths to the unix sockets appd listens on and passes to an app
+	// per the socket activation protocol, i.e. LISTEN_FDS. The sockets
+	// remain reachable for Caddy when the app runs in the private network.
+	ListenSockets []string `json:"listen_sockets,omitempty"`
+	// The Linux capabilities the commands retain, e.g.
+	// CAP_NET_BIND_SERVICE. When set, the commands lose all the other
+	// capabilities, and retain these ones regardless of User.
+	Capabilities []string `json:"capabilities,omitempty"`
+	// If set to true, the commands and their descendants cannot gain
+	// privileges, e.g. via setuid executables.
+	NoNewPrivileges bool `json:"no_new_privileges,omitempty"`
+	// The system calls, or the groups of system calls, e.g. @mount, the
+	// commands may use. The entries prefixed with tilde are denied. When
+	// any entry is allowed, the other system calls fail.
+	SyscallFilter []string `json:"syscall_filter,omitempty"`
+	// The Landlock sandbox of the commands. Unlike the other filesystem
+	// sandbox settings, it does not require privileges.
+	Landlock *Landlock `json:"landlock,omitempty"`
 	// The higher the Priority the sooner this unit activates. The Manager
 	// activates units with the same Priority in alphabetical order.
 	Priority uint64 `json:"priority,omitempty"`
 	// If set to true, the unit will not be started or stopped.
 	Noop bool `json:"noop,omitempty"`
-	// Not implemented.
+	// The names of the Units this unit pulls in. The unit starts after
+	// them and starts even when they fail.
 	Wants []string `json:"wants,omitempty"`
-	// Not implemented.
+	// The names of the Units this unit depends on. The unit starts after
+	// them, does not start when any of them fails, and stops when any of
+	// them stops.
 	Requires []string `json:"requires,omitempty"`
 	// The names of the Units that need to start after this one.
 	Before []string `json:"before,omitempty"`
@@ -55,6 +146,56 @@ type Unit struct {
 	StdOutFilePath string `json:"std_out_file_path,omitempty"`
 	// The path to err output file.
 	StdErrFilePath string `json:"std_err_file_path,omitempty"`
+	// The restart policy of an app: no, always, on-failure, or on-abnormal.
+	Restart string `json:"restart,omitempty"`
+	// The delay prior to restarting an app. The delay doubles with every
+	// consecutive restart.
+	RestartDelay Duration `json:"restart_delay,omitempty"`
+	// The upper bound of the delay prior to restarting an app.
+	RestartMaxDelay Duration `json:"restart_max_delay,omitempty"`
+	// The maximum number of restarts of an app within StartLimitInterval.
+	// Once exceeded, the app is no longer restarted.
+	StartLimitBurst int `json:"start_limit_burst,omitempty"`
+	// The time window for counting the restarts of an app.
+	StartLimitInterval Duration `json:"start_limit_interval,omitempty"`
+	// The probe that must succeed prior to considering an app started.
+	ReadyProbe *Probe `json:"ready_probe,omitempty"`
+	// The maximum time an app has to become ready.
+	ReadyTimeout Duration `json:"ready_timeout,omitempty"`
+	// The probe periodically checking the health of a running app.
+	HealthProbe *Probe `json:"health_probe,omitempty"`
+	// The maximum interval between WATCHDOG=1 messages from an app. When
+	// exceeded, the app is considered hung and gets terminated.
+	WatchdogInterval Duration `json:"watchdog_interval,omitempty"`
+	// The signal sent to a hung app prior to terminating it, e.g. SIGQUIT
+	// or SIGABRT to capture its stack traces or core dump.
+	WatchdogSignal string `json:"watchdog_signal,omitempty"`
+	// The processes receiving the stop signals and the kill: process,
+	// group, or mixed. Defaults to group.
+	KillMode string `json:"kill_mode,omitempty"`
+	// The signal stopping an app. Defaults to SIGINT.
+	StopSignal string `json:"stop_signal,omitempty"`
+	// The time an app has to exit after receiving the stop signal. Once
+	// exceeded, the app gets killed.
+	StopTimeout Duration `json:"stop_timeout,omitempty"`
+	// The signals stopping an app, one after another, until the app exits.
+	// When set, it overrides StopSignal.
+	StopEscalation []*StopStep `json:"stop_escalation,omitempty"`
+	// The command stopping an app, e.g. nginx -s quit. When the command
+	// fails or the app does not exit within the stop timeout, the app
+	// gets stopped with the stop signals.
+	ExecStop *Exec `json:"exec_stop,omitempty"`
+	// The command reloading the configuration of an app. When not set, the
+	// app receives SIGHUP signal.
+	ExecReload *Exec `json:"exec_reload,omitempty"`
+	// The commands running prior to the start of a unit. When any of them
+	// fails, the unit does not start.
+	ExecStartPre []*Exec `json:"exec_start_pre,omitempty"`
+	// The commands running after the start of a unit. When any of them
+	// fails, the unit start fails.
+	ExecStartPost []*Exec `json:"exec_start_post,omitempty"`
+	// The commands running after an app stops.
+	ExecStopPost []*Exec `json:"exec_stop_post,omitempty"`
 }
 
 // NewUnit returns an instance of Unit.
@@ -78,6 +219,132 @@ func NewUnit(kind, name string) (*Unit, error) {
 	return &Unit{Name: name, Kind: kind}, nil
 }
 
+// validate checks the settings of the unit.
+func (u *Unit) validate() error {
+	for k := range u.Environment {
+		if err := validateEnvName(k); err != nil {
+			return fmt.Errorf("unit %q: %w", u.Name, err)
+		}
+	}
+	for _, pattern := range u.EnvPassthrough {
+		if err := validateEnvPattern(pattern); err != nil {
+			return fmt.Errorf("unit %q: %w", u.Name, err)
+		}
+	}
+	for _, fp := range u.EnvFiles {
+		if fp == "" {
+			return fmt.Errorf("unit %q: empty env file path", u.Name)
+		}
+	}
+	switch u.ServiceType {
+	case "", SimpleServiceType:
+	case NotifyServiceType:
+		if u.Kind != "app" {
+			return fmt.Errorf("unit %q: %q service type is not supported for %q type", u.Name, u.ServiceType, u.Kind)
+		}
+	default:
+		return fmt.Errorf("unit %q: invalid %q service type", u.Name, u.ServiceType)
+	}
+	if err := validateRestartPolicy(u.Restart); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	if u.Kind != "app" && u.Restart != "" && u.Restart != RestartNo {
+		return fmt.Errorf("unit %q: restart policy is not supported for %q type", u.Name, u.Kind)
+	}
+	if u.RestartMaxDelay > 0 && u.RestartMaxDelay < u.RestartDelay {
+		return fmt.Errorf("unit %q: restart max delay is less than restart delay", u.Name)
+	}
+	if u.StartLimitBurst < 0 {
+		return fmt.Errorf("unit %q: start limit burst is negative", u.Name)
+	}
+	if u.ReadyProbe != nil {
+		if u.Kind != "app" {
+			return fmt.Errorf("unit %q: readiness probe is not supported for %q type", u.Name, u.Kind)
+		}
+		if err := u.ReadyProbe.validate(); err != nil {
+			return fmt.Errorf("unit %q: readiness probe: %w", u.Name, err)
+		}
+	}
+	if u.HealthProbe != nil {
+		if u.Kind != "app" {
+			return fmt.Errorf("unit %q: health probe is not supported for %q type", u.Name, u.Kind)
+		}
+		if err := u.HealthProbe.validate(); err != nil {
+			return fmt.Errorf("unit %q: health probe: %w", u.Name, err)
+		}
+	}
+	if err := u.validateLimits(); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	if err := u.validateCgroup(); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	if err := u.validateSandbox(); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	if err := u.validateListenSockets(); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	if err := u.validateCapabilities(); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	if err := u.validateSyscallFilter(); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	if err := u.validateLandlock(); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	if err := u.validateStop(); err != nil {
+		return fmt.Errorf("unit %q: %w", u.Name, err)
+	}
+	execs := map[string]*Exec{"stop": u.ExecStop, "reload": u.ExecReload}
+	for _, k := range []string{"stop", "reload"} {
+		e := execs[k]
+		if e == nil {
+			continue
+		}
+		if u.Kind != "app" {
+			return fmt.Errorf("unit %q: %s command is not supported for %q type", u.Name, k, u.Kind)
+		}
+		if err := e.validate(); err != nil {
+			return fmt.Errorf("unit %q: %s command: %w", u.Name, k, err)
+		}
+	}
+	hooks := map[string][]*Exec{
+		"exec_start_pre":  u.ExecStartPre,
+		"exec_start_post": u.ExecStartPost,
+		"exec_stop_post":  u.ExecStopPost,
+	}
+	for _, k := range []string{"exec_start_pre", "exec_start_post", "exec_stop_post"} {
+		for _, e := range hooks[k] {
+			if err := e.validate(); err != nil {
+				return fmt.Errorf("unit %q: %s command: %w", u.Name, k, err)
+			}
+		}
+	}
+	if u.Kind != "app" && len(u.ExecStopPost) > 0 {
+		return fmt.Errorf("unit %q: exec_stop_post command is not supported for %q type", u.Name, u.Kind)
+	}
+	if u.WatchdogInterval < 0 {
+		return fmt.Errorf("unit %q: watchdog interval is negative", u.Name)
+	}
+	if u.WatchdogInterval > 0 && u.Kind != "app" {
+		return fmt.Errorf("unit %q: watchdog is not supported for %q type", u.Name, u.Kind)
+	}
+	if (u.ServiceType == NotifyServiceType || u.WatchdogInterval > 0) && !notifySupported {
+		return fmt.Errorf("unit %q: sd_notify protocol is not supported on this platform", u.Name)
+	}
+	if u.WatchdogSignal != "" {
+		if u.WatchdogInterval == 0 {
+			return fmt.Errorf("unit %q: watchdog signal requires watchdog interval", u.Name)
+		}
+		if _, err := parseSignal(u.WatchdogSignal); err != nil {
+			return fmt.Errorf("unit %q: watchdog signal: %w", u.Name, err)
+		}
+	}
+	return nil
+}
+
 func validateFilePath(fp string) error {
 	fsfi, err := os.Stat(fp)
 	if err == nil {
@@ -100,3 +367,10 @@ func validateFilePath(fp string) error {
 	}
 	return err
 }
+
+func (u *Unit) readyTimeout() time.Duration {
+	if u.ReadyTimeout > 0 {
+		return time.Duration(u.ReadyTimeout)
+	}
+	return defaultReadyTimeout
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Paul Greenberg greenpau@outlook.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package services
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// watchdogScript verifies the watchdog environment, sends the provided
+// number of WATCHDOG=1 messages, and then the optional final message.
+const watchdogScript = `
+import os, socket, sys, time
+if os.environ.get("WATCHDOG_PID", str(os.getpid())) != str(os.getpid()):
+    sys.exit(3)
+if os.environ.get("WATCHDOG_USEC") != "300000":
+    sys.exit(4)
+s = socket.socket(socket.AF_UNIX, socket.SOCK_DGRAM)
+for i in range(int(sys.argv[1])):
+    s.sendto(b"WATCHDOG=1", os.environ["NOTIFY_SOCKET"])
+    time.sleep(0.1)
+if len(sys.argv) > 2:
+    s.sendto(sys.argv[2].encode(), os.environ["NOTIFY_SOCKET"])
+time.sleep(60)
+`
+
+func TestServiceWatchdog(t *testing.T) {
+	// The app must not inherit the watchdog of Caddy.
+	t.Setenv("WATCHDOG_PID", "1")
+	testcases := []struct {
+		name        string
+		args        []string
+		signal      string
+		minDuration time.Duration
+		err         string
+		exitSignal  string
+	}{
+		{
+			name:        "test app stops sending keep-alive messages",
+			args:        []string{"8"},
+			minDuration: 800 * time.Millisecond,
+			err:         "process terminated: watchdog timeout after 300ms",
+		},
+		{
+			name: "test app triggers watchdog",
+			args: []string{"2", "WATCHDOG=trigger"},
+			err:  "process terminated: watchdog triggered by app",
+		},
+		{
+			name:       "test hung app receives diagnostics signal",
+			args:       []string{"0"},
+			signal:     "SIGQUIT",
+			err:        "process terminated: watchdog timeout after 300ms",
+			exitSignal: "quit",
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			unit := &Unit{
+				Name:             "webapp",
+				Kind:             "app",
+				Command:          "python3",
+				Arguments:        append([]string{"-c", watchdogScript}, tc.args...),
+				WatchdogInterval: Duration(300 * time.Millisecond),
+				WatchdogSignal:   tc.signal,
+			}
+			if err := unit.validate(); err != nil {
+				t.Fatal(err)
+			}
+			svc, err := NewService(0, unit, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+			startedAt := time.Now()
+			if err := svc.Start(); err != nil {
+				t.Fatalf("expected success, got: %v", err)
+			}
+			defer svc.Stop()
+			if name := filepath.Base(svc.worker.Cmd.Path); name != "python3" {
+				t.Errorf("unexpected executable of app: %q, want: %q", name, "python3")
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) && svc.GetState().Current != StoppedState {
+				time.Sleep(20 * time.Millisecond)
+			}
+			if elapsed := time.Since(startedAt); elapsed < tc.minDuration {
+				t.Errorf("app terminated after %v, want at least %v", elapsed, tc.minDuration)
+			}
+
+			status := svc.GetStatus()
+			if status.Error == nil || !strings.Contains(status.Error.Error(), tc.err) {
+				t.Fatalf("unexpected status: %v, %v", status.Current, status.Error)
+			}
+			if tc.exitSignal != "" {
+				if state := svc.GetState(); state.ExitSignal != tc.exitSignal {
+					t.Errorf("unexpected exit signal: %q, want: %q", state.ExitSignal, tc.exitSignal)
+				}
+			}
+		})
+	}
+}
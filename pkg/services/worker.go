@@ -15,18 +15,23 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
 )
 
-var workerStopTimeout = time.Second * 4
+// workerDiagnosticsTimeout is the time the worker waits for a hung process
+// to handle the diagnostics signal prior to terminating it.
+var workerDiagnosticsTimeout = time.Second * 5
 
 type WorkerKind int
 
@@ -51,21 +56,85 @@ func (k WorkerKind) MarshalJSON() ([]byte, error) {
 type worker struct {
 	mu     sync.RWMutex
 	ID     uint
+	unit   *Unit
 	Cmd    *exec.Cmd
 	Pid    int
 	logger *zap.Logger
+	// The channel closed when the process exits.
+	done chan struct{}
+	// The exit status of the process. Set when the process exits.
+	exit *exitStatus
+	// Set to true when the worker stops the process.
+	stopping bool
+	// The reason the worker terminated the process, if any.
+	reason string
+	// The function called when the process exits.
+	onExit func(*exitStatus, bool)
+	// The steps of stopping the process.
+	stopSteps []stopStep
+	// The processes receiving the stop signals and the kill.
+	killMode string
+	// The receiver of sd_notify messages for notify-type apps and apps
+	// with watchdog.
+	notifier *notifier
+	// The cgroup of the process, if any. When set, the group kill mode
+	// covers all the processes in the cgroup rather than the process
+	// group.
+	cgroup *cgroup
 }
 
-func newWorker(id uint, binPath string, args []string, stdOutFilePath, stdErrFilePath string, logger *zap.Logger) (*worker, error) {
+// exitStatus is the outcome of a process.
+type exitStatus struct {
+	Code   int
+	Signal string
+	Time   time.Time
+	Error  error
+	// The reason the worker terminated the process, e.g. failed health
+	// check.
+	Reason string
+}
+
+func (e *exitStatus) String() string {
+	if e.Signal != "" {
+		return "killed by signal " + e.Signal
+	}
+	return fmt.Sprintf("exit code %d", e.Code)
+}
+
+// clean returns true when the process exited with zero exit code on its own.
+func (e *exitStatus) clean() bool {
+	return e.Signal == "" && e.Code == 0 && e.Error == nil && e.Reason == ""
+}
+
+// abnormal returns true when the process got killed by a signal or
+// terminated by the worker.
+func (e *exitStatus) abnormal() bool {
+	return e.Signal != "" || e.Reason != ""
+}
+
+func newWorker(id uint, unit *Unit, listeners []*socketListener, onExit func(*exitStatus, bool), onNotify func(*notifyMessage), logger *zap.Logger) (*worker, error) {
 	w := &worker{
-		ID:     id,
-		logger: logger,
+		ID:        id,
+		unit:      unit,
+		logger:    logger,
+		done:      make(chan struct{}),
+		onExit:    onExit,
+		stopSteps: unit.stopSteps(),
+		killMode:  unit.killMode(),
 	}
 
+	binPath, args := unit.Command, unit.Arguments
+	stdOutFilePath, stdErrFilePath := unit.StdOutFilePath, unit.StdErrFilePath
+
 	cmd := exec.Command(binPath, args...)
+	cmd.Dir = unit.WorkDirectory
+	env, err := unit.environ()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = env
 
 	var outFile, errFile *os.File
-	var err error
 
 	if stdOutFilePath == "" {
 		cmd.Stdout = os.Stdout
@@ -93,17 +162,158 @@ func newWorker(id uint, binPath string, args []string, stdOutFilePath, stdErrFil
 		cmd.Stderr = errFile
 	}
 
+	if unit.ServiceType == NotifyServiceType || unit.WatchdogInterval > 0 {
+		n, err := newNotifier()
+		if err != nil {
+			return nil, fmt.Errorf("failed creating notify socket: %w", err)
+		}
+		w.notifier = n
+		cmd.Env = append(cmd.Env, "NOTIFY_SOCKET="+n.path)
+		if c := unit.Credential; c != nil {
+			if err := n.chown(int(c.Uid), int(c.Gid)); err != nil {
+				n.close()
+				return nil, fmt.Errorf("failed changing owner of notify socket: %w", err)
+			}
+		}
+	}
+
+	spec := &launchSpec{}
+	if len(listeners) > 0 {
+		// The app inherits the sockets. The service keeps its copies
+		// across the restarts of the app.
+		for _, l := range listeners {
+			cmd.ExtraFiles = append(cmd.ExtraFiles, l.file)
+		}
+		cmd.Env = append(cmd.Env, listenEnv(unit.ListenSockets)...)
+		spec.ListenPid = true
+	}
+	if unit.WatchdogInterval > 0 {
+		usec := time.Duration(unit.WatchdogInterval).Microseconds()
+		cmd.Env = append(cmd.Env, "WATCHDOG_USEC="+strconv.FormatInt(usec, 10))
+		// The PID of the app is unknown prior to its start. When the app
+		// starts via the launcher for other reasons, the launcher exports
+		// it. Otherwise, the app accepts the watchdog without it, unless
+		// it inherits the stale one of Caddy.
+		cmd.Env = unsetEnv(cmd.Env, "WATCHDOG_PID")
+		spec.WatchdogPid = true
+	}
+	if w.notifier != nil {
+		// The notify socket resides in the temporary directory, which
+		// the filesystem sandbox may replace.
+		spec.KeepPaths = []string{w.notifier.dir}
+	}
+	if err := spec.prepare(cmd, unit); err != nil {
+		if w.notifier != nil {
+			w.notifier.close()
+		}
+		return nil, err
+	}
+
+	// The app and its descendants share the process group, which allows
+	// stopping all of them.
+	setProcessGroup(cmd)
+	if unit.CgroupPath != "" {
+		cg, err := openCgroup(unit)
+		if err != nil {
+			if w.notifier != nil {
+				w.notifier.close()
+			}
+			return nil, err
+		}
+		cg.place(cmd)
+		w.cgroup = cg
+	}
+
 	w.Cmd = cmd
-	if err := cmd.Start(); err != nil {
+	err = cmd.Start()
+	if w.cgroup != nil {
+		w.cgroup.started()
+	}
+	if err != nil {
+		if w.notifier != nil {
+			w.notifier.close()
+		}
+		if w.cgroup != nil {
+			w.cgroup.remove()
+		}
 		return nil, err
 	}
 	w.Pid = cmd.Process.Pid
+	if w.notifier != nil {
+		go w.notifier.serve(onNotify)
+	}
+	go w.supervise()
 	return w, nil
 }
 
+// supervise waits for the process to exit, reaps it, and records its exit
+// status.
+func (w *worker) supervise() {
+	err := w.Cmd.Wait()
+	if w.notifier != nil {
+		w.notifier.close()
+	}
+	exit := &exitStatus{
+		Code: -1,
+		Time: time.Now(),
+	}
+	if ps := w.Cmd.ProcessState; ps != nil {
+		exit.Code = ps.ExitCode()
+		if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			exit.Signal = ws.Signal().String()
+		}
+	} else {
+		exit.Error = err
+	}
+
+	w.mu.Lock()
+	exit.Reason = w.reason
+	w.exit = exit
+	stopping := w.stopping
+	w.mu.Unlock()
+
+	if !stopping && exit.Reason == "" && w.killMode != KillModeProcess && w.groupAlive() {
+		// The app exited on its own. Its descendants must not outlive it.
+		w.logger.Debug("worker killing remaining processes",
+			zap.Uint("worker_id", w.ID),
+			zap.Int("pgid", w.Pid),
+		)
+		if err := w.killGroup(); err != nil {
+			w.logger.Warn("worker failed killing remaining processes",
+				zap.Uint("worker_id", w.ID),
+				zap.Int("pgid", w.Pid),
+				zap.Error(err),
+			)
+		}
+	}
+	if w.cgroup != nil {
+		w.cgroup.remove()
+	}
+	close(w.done)
+
+	w.logger.Debug("worker process exited",
+		zap.Uint("worker_id", w.ID),
+		zap.Int("pid", w.Pid),
+		zap.Int("exit_code", exit.Code),
+		zap.String("exit_signal", exit.Signal),
+		zap.Bool("expected", stopping),
+	)
+
+	if w.onExit != nil {
+		w.onExit(exit, stopping)
+	}
+}
+
+// exitStatus returns the exit status of the process, or nil when the
+// process is running.
+func (w *worker) exitStatus() *exitStatus {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.exit
+}
+
 func (w *worker) stop() (*State, *Status) {
 	w.mu.Lock()
-	defer w.mu.Unlock()
 
 	state := &State{
 		Current: UnknownState,
@@ -112,6 +322,7 @@ func (w *worker) stop() (*State, *Status) {
 		Current: UnknownStatus,
 	}
 	if w.Pid < 1 {
+		w.mu.Unlock()
 		state.Current = CompletedState
 		status.Current = FailureStatus
 		status.Error = fmt.Errorf("pid is 0")
@@ -119,6 +330,7 @@ func (w *worker) stop() (*State, *Status) {
 	}
 
 	if w.Cmd == nil {
+		w.mu.Unlock()
 		state.Current = CompletedState
 		status.Current = FailureStatus
 		status.Error = fmt.Errorf("cmd exec is nil")
@@ -126,51 +338,262 @@ func (w *worker) stop() (*State, *Status) {
 	}
 
 	if w.Cmd.Process == nil {
+		w.mu.Unlock()
 		state.Current = CompletedState
 		status.Current = FailureStatus
 		status.Error = fmt.Errorf("process is nil")
 		return state, status
 	}
 
-	cmdResult := make(chan error, 1)
-	go func() {
-		_, err := w.Cmd.Process.Wait()
-		cmdResult <- err
-	}()
-
-	if err := w.Cmd.Process.Signal(os.Interrupt); err != nil {
+	if w.exit != nil {
+		// The process has already exited.
+		w.mu.Unlock()
 		state.Current = CompletedState
-		status.Current = FailureStatus
-		status.Error = err
+		status.Current = SuccessStatus
 		return state, status
 	}
 
+	w.stopping = true
+	w.mu.Unlock()
+
+	if w.unit.ExecStop != nil && w.execStop() {
+		return w.finish(state, status)
+	}
+	return w.terminate()
+}
+
+// execStop runs the stop command of the app and waits for the process to
+// exit within the stop timeout. It returns false when the command fails or
+// the process does not exit.
+func (w *worker) execStop() bool {
+	e := w.unit.ExecStop
+	deadline := time.Now().Add(w.unit.stopTimeout())
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := newAdhocWorker(ctx, w.unit, w, e.Command, e.Arguments, w.execEnv()); err != nil {
+		w.logger.Warn("worker failed running stop command",
+			zap.Uint("worker_id", w.ID),
+			zap.Int("pid", w.Pid),
+			zap.String("cmd", e.Command),
+			zap.Error(err),
+		)
+		return false
+	}
+	if !w.wait(time.Until(deadline)) {
+		w.logger.Warn("worker process outlived stop command",
+			zap.Uint("worker_id", w.ID),
+			zap.Int("pid", w.Pid),
+			zap.String("cmd", e.Command),
+		)
+		return false
+	}
+	return true
+}
+
+// reload runs the reload command of the app, or sends SIGHUP signal to the
+// process when the app has no reload command.
+func (w *worker) reload() error {
 	select {
-	case err := <-cmdResult:
-		state.Current = CompletedState
-		status.Current = FailureStatus
-		status.Error = err
-		break
-	case <-time.After(workerStopTimeout):
-		if err := w.Cmd.Process.Kill(); err != nil {
+	case <-w.done:
+		return fmt.Errorf("process exited")
+	default:
+	}
+	e := w.unit.ExecReload
+	if e == nil {
+		return w.Cmd.Process.Signal(syscall.SIGHUP)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultReloadTimeout)
+	defer cancel()
+	return newAdhocWorker(ctx, w.unit, w, e.Command, e.Arguments, w.execEnv())
+}
+
+// execEnv returns the environment variables of the stop and reload
+// commands, in addition to the environment of the app.
+func (w *worker) execEnv() []string {
+	return []string{"MAINPID=" + strconv.Itoa(w.Pid)}
+}
+
+// kill terminates the running process for the provided reason. The exit
+// of the process is unexpected and subject to the restart policy. When
+// the diagnostics signal is provided, the process receives it first, e.g.
+// to dump its stack traces.
+func (w *worker) kill(reason string, diag os.Signal) {
+	w.mu.Lock()
+	if w.exit != nil || w.stopping {
+		w.mu.Unlock()
+		return
+	}
+	w.reason = reason
+	w.mu.Unlock()
+	if diag != nil {
+		if err := w.Cmd.Process.Signal(diag); err == nil {
+			select {
+			case <-w.done:
+				return
+			case <-time.After(workerDiagnosticsTimeout):
+			}
+		}
+	}
+	w.terminate()
+}
+
+// terminate sends the stop signals to the process, one after another,
+// until the process exits. It kills the process if it does not exit after
+// the last signal within its timeout. Depending on the kill mode, the
+// signals and the kill reach the other processes in the process group.
+func (w *worker) terminate() (*State, *Status) {
+	state := &State{
+		Current: UnknownState,
+	}
+	status := &Status{
+		Current: UnknownStatus,
+	}
+
+	for _, step := range w.stopSteps {
+		if err := w.signal(step.signal, w.killMode == KillModeGroup); err != nil {
+			select {
+			case <-w.done:
+				// The process exited prior to receiving the signal.
+				return w.finish(state, status)
+			default:
+			}
+			state.Current = CompletedState
+			status.Current = FailureStatus
+			status.Error = err
+			return state, status
+		}
+
+		w.logger.Debug("worker sent stop signal",
+			zap.Uint("worker_id", w.ID),
+			zap.Int("pid", w.Pid),
+			zap.String("signal", step.signal.String()),
+			zap.String("kill_mode", w.killMode),
+			zap.Duration("timeout", step.timeout),
+		)
+
+		if w.wait(step.timeout) {
+			return w.finish(state, status)
+		}
+	}
+
+	if err := w.signal(os.Kill, w.killMode != KillModeProcess); err != nil {
+		select {
+		case <-w.done:
+		default:
 			state.Current = CompletedState
 			status.Current = FailureStatus
 			status.Error = fmt.Errorf("force terminated failed: %w", err)
 			return state, status
 		}
-		state.Current = CompletedState
+	}
+	<-w.done
+	state, status = w.finish(state, status)
+	if status.Error == nil {
 		status.Current = FailureStatus
 		status.Error = fmt.Errorf("force terminated process")
-		break
 	}
+	return state, status
+}
+
+// signal sends the signal to the process, or to its group.
+func (w *worker) signal(sig os.Signal, group bool) error {
+	if !group {
+		return w.Cmd.Process.Signal(sig)
+	}
+	if w.cgroup != nil {
+		return w.cgroup.signal(sig)
+	}
+	return signalGroup(w.Pid, sig)
+}
+
+// groupAlive returns true when any process remains in the cgroup, or in
+// the process group of the process.
+func (w *worker) groupAlive() bool {
+	if w.cgroup != nil {
+		return w.cgroup.alive()
+	}
+	return groupAlive(w.Pid)
+}
+
+// wait waits for the process to exit within the timeout. In group kill
+// mode, it also waits for the other processes in the process group.
+func (w *worker) wait(timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	select {
+	case <-w.done:
+	case <-deadline.C:
+		return false
+	}
+	if w.killMode != KillModeGroup {
+		return true
+	}
+	for w.groupAlive() {
+		select {
+		case <-deadline.C:
+			return false
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return true
+}
 
+// finish kills the processes remaining in the process group after the
+// exit of the process, unless the kill mode is process, and verifies that
+// none of them remain.
+func (w *worker) finish(state *State, status *Status) (*State, *Status) {
+	state.Current = CompletedState
+	status.Current = SuccessStatus
+	if w.killMode == KillModeProcess {
+		return state, status
+	}
+	if err := w.killGroup(); err != nil {
+		status.Current = FailureStatus
+		status.Error = err
+	}
+	if w.cgroup != nil {
+		w.cgroup.remove()
+	}
 	return state, status
 }
 
-func newAdhocWorker(binPath string, args []string, stdOutFilePath, stdErrFilePath string) error {
-	cmd := exec.Command(binPath, args...)
+// killGroup kills the processes in the cgroup, or in the process group,
+// and waits for them to exit.
+func (w *worker) killGroup() error {
+	if !w.groupAlive() {
+		return nil
+	}
+	if w.cgroup != nil {
+		w.cgroup.kill()
+	} else {
+		signalGroup(w.Pid, os.Kill)
+	}
+	deadline := time.Now().Add(groupKillTimeout)
+	for time.Now().Before(deadline) {
+		if !w.groupAlive() {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.cgroup != nil {
+		return fmt.Errorf("processes remain in cgroup %s", w.cgroup.path)
+	}
+	return fmt.Errorf("descendant processes remain in process group %d", w.Pid)
+}
+
+// newAdhocWorker runs the command to completion. The command uses the
+// working directory, the environment, and the output files of the unit,
+// with the provided environment variables added. When the app is provided,
+// the command joins the mount and network namespaces of the app instead of
+// creating its own, e.g. to share the private /tmp of the app.
+func newAdhocWorker(ctx context.Context, unit *Unit, app *worker, binPath string, args []string, env []string) error {
+	stdOutFilePath, stdErrFilePath := unit.StdOutFilePath, unit.StdErrFilePath
+	cmd, err := newAdhocCommand(ctx, unit, app, binPath, args, env)
+	if err != nil {
+		return err
+	}
+
 	var outFile, errFile *os.File
-	var err error
 
 	if stdOutFilePath == "" {
 		cmd.Stdout = os.Stdout
@@ -198,8 +621,50 @@ func newAdhocWorker(binPath string, args []string, stdOutFilePath, stdErrFilePat
 		cmd.Stderr = errFile
 	}
 
-	if err := cmd.Run(); err != nil {
+	return runAdhocCommand(cmd, unit)
+}
+
+// newAdhocCommand returns the command with the working directory, the
+// environment, the credential, and the sandbox of the unit. When the app
+// is provided, the command joins its namespaces.
+func newAdhocCommand(ctx context.Context, unit *Unit, app *worker, binPath string, args []string, env []string) (*exec.Cmd, error) {
+	cmd := exec.CommandContext(ctx, binPath, args...)
+	cmd.Dir = unit.WorkDirectory
+	unitEnv, err := unit.environ()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = append(unitEnv, env...)
+	spec := &launchSpec{}
+	if app != nil && unit.namespaced() {
+		spec.JoinPid = app.Pid
+	}
+	if err := spec.prepare(cmd, unit); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// runAdhocCommand runs the command in the cgroup of the unit and waits for
+// it to complete.
+func runAdhocCommand(cmd *exec.Cmd, unit *Unit) error {
+	var cg *cgroup
+	var err error
+	if unit.CgroupPath != "" {
+		if cg, err = openCgroup(unit); err != nil {
+			return err
+		}
+		cg.place(cmd)
+	}
+	err = cmd.Start()
+	if cg != nil {
+		cg.started()
+		// The removal fails while the descendants of the command remain
+		// in the cgroup.
+		defer cg.remove()
+	}
+	if err != nil {
 		return err
 	}
-	return nil
+	return cmd.Wait()
 }